@@ -0,0 +1,472 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpoint is returned when a balancer has no endpoint left to send
+// a request to
+var ErrNoEndpoint = errors.New("req: no endpoint available")
+
+// Default balancer settings
+const (
+	DefaultBalancerMaxFailures         = 3
+	DefaultBalancerEjectionTime        = 30 * time.Second
+	DefaultBalancerHealthCheckInterval = 10 * time.Second
+	DefaultBalancerHealthCheckTimeout  = 2 * time.Second
+)
+
+// BalanceStrategy is how a balancer picks the endpoint of a request
+type BalanceStrategy int
+
+// Balance strategies
+const (
+	// RoundRobin picks the endpoints in turn
+	RoundRobin BalanceStrategy = iota
+	// WeightedRoundRobin picks the endpoints in turn in proportion to
+	// their weight
+	WeightedRoundRobin
+	// LeastOutstanding picks the endpoint with the fewest requests in
+	// flight
+	LeastOutstanding
+	// PowerOfTwoChoices picks the endpoint with the fewer requests in
+	// flight out of two random ones
+	PowerOfTwoChoices
+)
+
+// Endpoint is a base url requests are balanced over
+type Endpoint struct {
+	URL string `json:"url" yaml:"url"`
+	// Weight is the relative share of requests of the endpoint with
+	// WeightedRoundRobin, 1 by default
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// BalancerPolicy describes how requests are balanced and how the health
+// of the endpoints is tracked
+type BalancerPolicy struct {
+	Strategy BalanceStrategy
+	// MaxFailures ejects an endpoint after that many failed requests in a
+	// row, errors and 5xx responses being failures
+	MaxFailures int
+	// EjectionTime is how long an ejected endpoint is left out
+	EjectionTime time.Duration
+	// HealthCheckPath, when set, is requested with GET on every endpoint
+	// each HealthCheckInterval, endpoints not answering with a 2xx being
+	// left out until they do
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// Transport sends the health checks, by default the transport of the
+	// first client installing the balancer, so that they go through the
+	// same TLS configuration, proxy and dialer as the requests. Health
+	// checks start once it is known
+	Transport http.RoundTripper
+	// DisableFailover stops retrying a request on another endpoint when
+	// the connection to the first one fails
+	DisableFailover bool
+}
+
+// EndpointStatus is the state of a balancer endpoint
+type EndpointStatus struct {
+	URL         string
+	Healthy     bool
+	Outstanding int
+}
+
+// Balancer spreads requests with a relative url over a set of endpoints,
+// resolving the url against the endpoint picked for each attempt. Requests
+// with an absolute url are sent as is, so a balancer takes the place of
+// SetBaseURL. It can be shared by several clients and must be closed
+// when active health checks are enabled
+type Balancer struct {
+	policy BalancerPolicy
+
+	mu        sync.Mutex
+	client    *http.Client
+	endpoints []*endpoint
+	next      int
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type endpoint struct {
+	base        string
+	weight      int
+	current     int
+	outstanding int
+	failures    int
+	ejected     time.Time
+	down        bool
+}
+
+// NewBalancer create a balancer over endpoints, which must be absolute urls
+func NewBalancer(endpoints []Endpoint, policy BalancerPolicy) (*Balancer, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	if policy.MaxFailures <= 0 {
+		policy.MaxFailures = DefaultBalancerMaxFailures
+	}
+	if policy.EjectionTime <= 0 {
+		policy.EjectionTime = DefaultBalancerEjectionTime
+	}
+	if policy.HealthCheckInterval <= 0 {
+		policy.HealthCheckInterval = DefaultBalancerHealthCheckInterval
+	}
+	if policy.HealthCheckTimeout <= 0 {
+		policy.HealthCheckTimeout = DefaultBalancerHealthCheckTimeout
+	}
+
+	b := &Balancer{
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	if err := b.SetEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+	if policy.Transport != nil {
+		b.useTransport(policy.Transport)
+	}
+	return b, nil
+}
+
+// SetBalancer spreads requests with a relative url over the endpoints of b
+func SetBalancer(b *Balancer) Option {
+	return func(o *options) {
+		o.balancer = b
+	}
+}
+
+// SetEndpoints replaces the endpoints of b, those already known keeping
+// their health and requests in flight
+func (b *Balancer) SetEndpoints(endpoints []Endpoint) error {
+	if len(endpoints) == 0 {
+		return ErrNoEndpoint
+	}
+	for _, e := range endpoints {
+		if !isAbsoluteURL(e.URL) {
+			return fmt.Errorf("req: endpoint %q is not an absolute url", e.URL)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known := make(map[string]*endpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		known[e.base] = e
+	}
+	b.endpoints = b.endpoints[:0:0]
+	for _, e := range endpoints {
+		weight := e.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		ep, ok := known[e.URL]
+		if !ok {
+			ep = &endpoint{base: e.URL}
+		}
+		ep.weight = weight
+		b.endpoints = append(b.endpoints, ep)
+	}
+	return nil
+}
+
+// Endpoints returns the state of the endpoints
+func (b *Balancer) Endpoints() []EndpointStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	status := make([]EndpointStatus, len(b.endpoints))
+	for i, e := range b.endpoints {
+		status[i] = EndpointStatus{URL: e.base, Healthy: e.available(now), Outstanding: e.outstanding}
+	}
+	return status
+}
+
+// Close stops the active health checks
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	b.mu.Unlock()
+	b.wg.Wait()
+	return nil
+}
+
+// useTransport sends the health checks through rt unless a transport is
+// already set, starting them
+func (b *Balancer) useTransport(rt http.RoundTripper) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		return
+	}
+	b.client = &http.Client{Transport: rt}
+
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	if b.policy.HealthCheckPath != "" {
+		b.wg.Add(1)
+		go b.healthCheck()
+	}
+}
+
+func (b *Balancer) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		if req.URL.Scheme != "" || req.URL.Host != "" {
+			return next(ctx, req)
+		}
+		b.mu.Lock()
+		n := len(b.endpoints)
+		b.mu.Unlock()
+		if !b.policy.DisableFailover && n > 1 {
+			if err := rewindableBody(req); err != nil {
+				return nil, err
+			}
+		}
+
+		tried := make(map[*endpoint]bool)
+		lastErr := ErrNoEndpoint
+		for {
+			e := b.pick(tried)
+			if e == nil {
+				return nil, lastErr
+			}
+			tried[e] = true
+
+			areq, err := b.rewrite(ctx, req, e, len(tried) > 1)
+			if err != nil {
+				b.release(e, nil, err)
+				return nil, err
+			}
+			resp, err := next(ctx, areq)
+			var res *http.Response
+			if err == nil {
+				res, err = httpResponse(resp)
+			}
+			if err != nil {
+				b.release(e, nil, err)
+				if b.policy.DisableFailover || ctx.Err() != nil || !isDialError(err) {
+					return nil, err
+				}
+				lastErr = err
+				continue
+			}
+
+			if res.Body == nil {
+				b.release(e, res, nil)
+			} else {
+				var once sync.Once
+				res.Body = &cancelBody{ReadCloser: res.Body, cancel: func() {
+					once.Do(func() { b.release(e, res, nil) })
+				}}
+			}
+			return resp, nil
+		}
+	}
+}
+
+// rewrite returns a copy of req sent to the endpoint e
+func (b *Balancer) rewrite(ctx context.Context, req *http.Request, e *endpoint, rewind bool) (*http.Request, error) {
+	u, err := ResolveURL(e.base, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	areq := req.Clone(ctx)
+	areq.URL = u
+	// a Host set by the caller, as for virtual hosts, is kept
+	if areq.Host == "" || areq.Host == req.URL.Host {
+		areq.Host = u.Host
+	}
+	if rewind && req.GetBody != nil {
+		if areq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return areq, nil
+}
+
+// pick selects an endpoint out of those not tried yet, preferring the
+// healthy ones, and counts a request in flight on it
+func (b *Balancer) pick(tried map[*endpoint]bool) *endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var candidates []*endpoint
+	for _, e := range b.endpoints {
+		if !tried[e] && e.available(now) {
+			candidates = append(candidates, e)
+		}
+	}
+	// when every endpoint is unhealthy, trying one beats failing
+	if len(candidates) == 0 {
+		for _, e := range b.endpoints {
+			if !tried[e] {
+				candidates = append(candidates, e)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var e *endpoint
+	switch b.policy.Strategy {
+	case WeightedRoundRobin:
+		// smooth weighted round robin
+		total := 0
+		for _, c := range candidates {
+			c.current += c.weight
+			total += c.weight
+			if e == nil || c.current > e.current {
+				e = c
+			}
+		}
+		e.current -= total
+	case LeastOutstanding:
+		start := b.next % len(candidates)
+		b.next++
+		for i := range candidates {
+			c := candidates[(start+i)%len(candidates)]
+			if e == nil || c.outstanding < e.outstanding {
+				e = c
+			}
+		}
+	case PowerOfTwoChoices:
+		i := rand.Intn(len(candidates))
+		e = candidates[i]
+		if len(candidates) > 1 {
+			j := rand.Intn(len(candidates) - 1)
+			if j >= i {
+				j++
+			}
+			if c := candidates[j]; c.outstanding < e.outstanding {
+				e = c
+			}
+		}
+	default:
+		e = candidates[b.next%len(candidates)]
+		b.next++
+	}
+
+	e.outstanding++
+	return e
+}
+
+// release ends a request on e, tracking the endpoint health
+func (b *Balancer) release(e *endpoint, res *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.outstanding--
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil && res.StatusCode < 500 {
+		e.failures = 0
+		return
+	}
+	if e.failures++; e.failures >= b.policy.MaxFailures {
+		e.failures = 0
+		e.ejected = time.Now().Add(b.policy.EjectionTime)
+	}
+}
+
+func (b *Balancer) healthCheck() {
+	defer b.wg.Done()
+
+	t := time.NewTicker(b.policy.HealthCheckInterval)
+	defer t.Stop()
+	for {
+		b.checkAll()
+		select {
+		case <-t.C:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Balancer) checkAll() {
+	b.mu.Lock()
+	endpoints := append([]*endpoint(nil), b.endpoints...)
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			healthy := b.check(e)
+
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			e.down = !healthy
+			if healthy {
+				e.failures = 0
+				e.ejected = time.Time{}
+			}
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (b *Balancer) check(e *endpoint) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), b.policy.HealthCheckTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	u, err := ResolveURL(e.base, b.policy.HealthCheckPath)
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	res, err := b.client.Do(req)
+	if err != nil {
+		return false
+	}
+	drainBody(res.Body)
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+func (e *endpoint) available(now time.Time) bool {
+	return !e.down && !now.Before(e.ejected)
+}
+
+// isDialError reports whether err happened while connecting, the request
+// not having been sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
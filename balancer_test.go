@@ -0,0 +1,277 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type balancedServer struct {
+	*httptest.Server
+	hits   int32
+	status int32
+	health int32
+}
+
+func newBalancedServer(name string) *balancedServer {
+	s := &balancedServer{status: http.StatusOK, health: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(int(atomic.LoadInt32(&s.health)))
+			return
+		}
+		atomic.AddInt32(&s.hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(int(atomic.LoadInt32(&s.status)))
+		fmt.Fprintf(w, "%s %s %s", name, r.URL.RequestURI(), body)
+	}))
+	return s
+}
+
+func TestBalancer(t *testing.T) {
+	a, b, c := newBalancedServer("a"), newBalancedServer("b"), newBalancedServer("c")
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	ctx := context.Background()
+	reset := func() {
+		for _, s := range []*balancedServer{a, b, c} {
+			atomic.StoreInt32(&s.hits, 0)
+			atomic.StoreInt32(&s.status, http.StatusOK)
+			atomic.StoreInt32(&s.health, http.StatusOK)
+		}
+	}
+	hits := func() []int32 {
+		return []int32{atomic.LoadInt32(&a.hits), atomic.LoadInt32(&b.hits), atomic.LoadInt32(&c.hits)}
+	}
+
+	Convey("Invalid endpoints", t, func() {
+		_, err := NewBalancer(nil, BalancerPolicy{})
+		So(err, ShouldEqual, ErrNoEndpoint)
+		_, err = NewBalancer([]Endpoint{{URL: "/relative"}}, BalancerPolicy{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Round robin", t, func() {
+		reset()
+		lb, err := NewBalancer([]Endpoint{{URL: a.URL}, {URL: b.URL + "/api"}, {URL: c.URL}}, BalancerPolicy{})
+		So(err, ShouldBeNil)
+		r := New(SetBalancer(lb))
+
+		resp, err := r.Get(ctx, "/users", map[string][]string{"q": {"1"}})
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldEqual, "a /users?q=1 ")
+		resp, err = r.Get(ctx, "users", nil)
+		So(err, ShouldBeNil)
+		s, _ = resp.String()
+		So(s, ShouldEqual, "b /api/users ")
+
+		for i := 0; i < 4; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		So(hits(), ShouldResemble, []int32{2, 2, 2})
+
+		// absolute urls are sent as is
+		resp, err = r.Get(ctx, c.URL+"/direct", nil)
+		So(err, ShouldBeNil)
+		s, _ = resp.String()
+		So(s, ShouldEqual, "c /direct ")
+	})
+
+	Convey("Replacing endpoints", t, func() {
+		reset()
+		lb, err := NewBalancer([]Endpoint{{URL: dead.URL}, {URL: a.URL}}, BalancerPolicy{MaxFailures: 1})
+		So(err, ShouldBeNil)
+		r := New(SetBalancer(lb))
+
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		resp.Close()
+		So(lb.Endpoints()[0].Healthy, ShouldBeFalse)
+
+		So(lb.SetEndpoints(nil), ShouldEqual, ErrNoEndpoint)
+		So(lb.SetEndpoints([]Endpoint{{URL: b.URL}, {URL: dead.URL}}), ShouldBeNil)
+		status := lb.Endpoints()
+		So(status[0].URL, ShouldEqual, b.URL)
+		So(status[1].Healthy, ShouldBeFalse)
+
+		resp, err = r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldEqual, "b / ")
+	})
+
+	Convey("Host set by the caller", t, func() {
+		vhost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Host)
+		}))
+		defer vhost.Close()
+
+		lb, err := NewBalancer([]Endpoint{{URL: vhost.URL}}, BalancerPolicy{})
+		So(err, ShouldBeNil)
+		r := New(SetBalancer(lb))
+
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldEqual, vhost.Listener.Addr().String())
+
+		resp, err = r.Get(ctx, "/", nil, SetRequest(func(req *http.Request) (*http.Request, error) {
+			req.Host = "virtual.example"
+			return req, nil
+		}))
+		So(err, ShouldBeNil)
+		s, _ = resp.String()
+		So(s, ShouldEqual, "virtual.example")
+	})
+
+	Convey("Weighted round robin", t, func() {
+		reset()
+		lb, err := NewBalancer([]Endpoint{{URL: a.URL, Weight: 3}, {URL: b.URL}}, BalancerPolicy{Strategy: WeightedRoundRobin})
+		So(err, ShouldBeNil)
+		r := New(SetBalancer(lb))
+
+		for i := 0; i < 8; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		So(hits(), ShouldResemble, []int32{6, 2, 0})
+	})
+
+	Convey("Outstanding requests", t, func() {
+		for _, strategy := range []BalanceStrategy{LeastOutstanding, PowerOfTwoChoices} {
+			reset()
+			lb, err := NewBalancer([]Endpoint{{URL: a.URL}, {URL: b.URL}}, BalancerPolicy{Strategy: strategy})
+			So(err, ShouldBeNil)
+			r := New(SetBalancer(lb))
+
+			// the body of the first response is left open
+			first, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			busy := first.Response().Request.URL.Host
+
+			for i := 0; i < 3; i++ {
+				resp, err := r.Get(ctx, "/", nil)
+				So(err, ShouldBeNil)
+				So(resp.Response().Request.URL.Host, ShouldNotEqual, busy)
+				resp.Close()
+			}
+
+			first.Close()
+			for _, st := range lb.Endpoints() {
+				So(st.Outstanding, ShouldEqual, 0)
+			}
+		}
+	})
+
+	Convey("Failover on connection errors", t, func() {
+		reset()
+		lb, err := NewBalancer([]Endpoint{{URL: dead.URL}, {URL: a.URL}}, BalancerPolicy{MaxFailures: 2})
+		So(err, ShouldBeNil)
+		r := New(SetBalancer(lb))
+
+		for i := 0; i < 4; i++ {
+			resp, err := r.Post(ctx, "/", strings.NewReader("body"))
+			So(err, ShouldBeNil)
+			s, _ := resp.String()
+			So(s, ShouldEqual, "a / body")
+		}
+		So(lb.Endpoints()[0].Healthy, ShouldBeFalse)
+		So(lb.Endpoints()[1].Healthy, ShouldBeTrue)
+
+		Convey("unless disabled", func() {
+			lb, err := NewBalancer([]Endpoint{{URL: dead.URL}}, BalancerPolicy{DisableFailover: true})
+			So(err, ShouldBeNil)
+			_, err = New(SetBalancer(lb)).Get(ctx, "/", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Passive ejection", t, func() {
+		reset()
+		atomic.StoreInt32(&a.status, http.StatusInternalServerError)
+		lb, err := NewBalancer([]Endpoint{{URL: a.URL}, {URL: b.URL}}, BalancerPolicy{MaxFailures: 2, EjectionTime: time.Minute})
+		So(err, ShouldBeNil)
+		r := New(SetBalancer(lb))
+
+		for i := 0; i < 8; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		So(hits(), ShouldResemble, []int32{2, 6, 0})
+		So(lb.Endpoints()[0].Healthy, ShouldBeFalse)
+	})
+
+	Convey("Active health checks", t, func() {
+		reset()
+		atomic.StoreInt32(&b.health, http.StatusServiceUnavailable)
+		lb, err := NewBalancer([]Endpoint{{URL: a.URL}, {URL: b.URL}}, BalancerPolicy{
+			HealthCheckPath:     "/health",
+			HealthCheckInterval: 10 * time.Millisecond,
+		})
+		So(err, ShouldBeNil)
+		defer lb.Close()
+		r := New(SetBalancer(lb))
+
+		waitHealthy := func(want bool) {
+			for i := 0; i < 200 && lb.Endpoints()[1].Healthy != want; i++ {
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(lb.Endpoints()[1].Healthy, ShouldEqual, want)
+		}
+		waitHealthy(false)
+
+		for i := 0; i < 4; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		So(hits(), ShouldResemble, []int32{4, 0, 0})
+
+		atomic.StoreInt32(&b.health, http.StatusOK)
+		waitHealthy(true)
+	})
+
+	Convey("Health checks use the client transport", t, func() {
+		var checks int32
+		secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&checks, 1)
+		}))
+		defer secure.Close()
+
+		lb, err := NewBalancer([]Endpoint{{URL: secure.URL}}, BalancerPolicy{
+			HealthCheckPath:     "/health",
+			HealthCheckInterval: 10 * time.Millisecond,
+		})
+		So(err, ShouldBeNil)
+		defer lb.Close()
+
+		// no check before a client installs the balancer
+		time.Sleep(30 * time.Millisecond)
+		So(atomic.LoadInt32(&checks), ShouldEqual, 0)
+
+		New(SetBalancer(lb), SetTransport(secure.Client().Transport.(*http.Transport)))
+		for i := 0; i < 200 && atomic.LoadInt32(&checks) < 2; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		So(atomic.LoadInt32(&checks), ShouldBeGreaterThanOrEqualTo, 2)
+		So(lb.Endpoints()[0].Healthy, ShouldBeTrue)
+	})
+}
@@ -0,0 +1,372 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending the request while the circuit
+// of its upstream is open
+var ErrCircuitOpen = errors.New("req: circuit open")
+
+// Default circuit breaker settings
+const (
+	DefaultBreakerConsecutiveFailures = 5
+	DefaultBreakerMinRequests         = 10
+	DefaultBreakerWindow              = 10 * time.Second
+	DefaultBreakerCoolDown            = 5 * time.Second
+	DefaultBreakerHalfOpenProbes      = 1
+)
+
+// breakerSlots is the number of slots the failure rate window is split in
+const breakerSlots = 10
+
+// BreakerState is the state of a circuit
+type BreakerState int
+
+// Circuit states
+const (
+	// BreakerClosed lets requests through, counting their failures
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests with ErrCircuitOpen until the cool-down
+	// is over
+	BreakerOpen
+	// BreakerHalfOpen lets probe requests through, closing the circuit
+	// if they all succeed and opening it again on the first failure
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerPolicy describes when the circuit of an upstream opens and how it
+// recovers. Without any threshold set, the circuit opens after
+// DefaultBreakerConsecutiveFailures failures in a row
+type BreakerPolicy struct {
+	// ConsecutiveFailures opens the circuit after that many failures in a
+	// row, 0 disabling the threshold
+	ConsecutiveFailures int
+	// FailureRate opens the circuit once the ratio of failed requests over
+	// Window reaches it, 0 disabling the threshold
+	FailureRate float64
+	// MinRequests is the number of requests over Window below which
+	// FailureRate is not applied
+	MinRequests int
+	// Window is the rolling period FailureRate is measured over
+	Window time.Duration
+	// CoolDown is how long the circuit stays open before probing
+	CoolDown time.Duration
+	// HalfOpenProbes is the number of requests let through to probe the
+	// upstream once the cool-down is over
+	HalfOpenProbes int
+	// Key maps a request to its circuit, the request host by default
+	Key func(req *http.Request) string
+	// IsFailure classifies the outcome of a request, by default errors
+	// other than a canceled context and 5xx responses being failures
+	IsFailure func(resp *http.Response, err error) bool
+	// OnStateChange is called after the circuit of key changed state
+	OnStateChange func(key string, from, to BreakerState)
+}
+
+// CircuitBreaker tracks the health of upstreams with a circuit per key.
+// It can be shared by several clients
+type CircuitBreaker struct {
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state BreakerState
+	// generation discards the outcome of requests admitted before the
+	// last state change
+	generation  uint64
+	consecutive int
+	slots       [breakerSlots]breakerSlot
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+type breakerSlot struct {
+	epoch    int64
+	total    int
+	failures int
+}
+
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota
+	breakerFailure
+	breakerIgnored
+)
+
+type stateChange struct {
+	key      string
+	from, to BreakerState
+}
+
+// NewCircuitBreaker create a circuit breaker
+func NewCircuitBreaker(policy BreakerPolicy) *CircuitBreaker {
+	if policy.ConsecutiveFailures <= 0 && policy.FailureRate <= 0 {
+		policy.ConsecutiveFailures = DefaultBreakerConsecutiveFailures
+	}
+	if policy.MinRequests <= 0 {
+		policy.MinRequests = DefaultBreakerMinRequests
+	}
+	if policy.Window <= 0 {
+		policy.Window = DefaultBreakerWindow
+	}
+	if policy.CoolDown <= 0 {
+		policy.CoolDown = DefaultBreakerCoolDown
+	}
+	if policy.HalfOpenProbes <= 0 {
+		policy.HalfOpenProbes = DefaultBreakerHalfOpenProbes
+	}
+	return &CircuitBreaker{
+		policy:   policy,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// SetCircuitBreaker guards requests with b
+func SetCircuitBreaker(b *CircuitBreaker) Option {
+	return func(o *options) {
+		o.breaker = b
+	}
+}
+
+// SetBreaker guards requests with a circuit breaker of its own following
+// the policy
+func SetBreaker(policy BreakerPolicy) Option {
+	return SetCircuitBreaker(NewCircuitBreaker(policy))
+}
+
+// State returns the state of the circuit of key
+func (b *CircuitBreaker) State(key string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return BreakerClosed
+	}
+	if c.state == BreakerOpen && time.Since(c.openedAt) >= b.policy.CoolDown {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+func (b *CircuitBreaker) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		key := b.key(req)
+		generation, err := b.allow(key, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := next(ctx, req)
+		var res *http.Response
+		if err == nil {
+			res = resp.Response()
+		}
+		b.record(key, generation, b.outcome(res, err), time.Now())
+		return resp, err
+	}
+}
+
+func (b *CircuitBreaker) key(req *http.Request) string {
+	if b.policy.Key != nil {
+		return b.policy.Key(req)
+	}
+	return req.URL.Host
+}
+
+func (b *CircuitBreaker) outcome(res *http.Response, err error) breakerOutcome {
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrConcurrencyLimited) {
+		return breakerIgnored
+	}
+
+	failed := err != nil || (res != nil && res.StatusCode >= 500)
+	if b.policy.IsFailure != nil {
+		failed = b.policy.IsFailure(res, err)
+	}
+	if failed {
+		return breakerFailure
+	}
+	return breakerSuccess
+}
+
+// allow admits a request on the circuit of key, returning the circuit
+// generation its outcome is recorded against
+func (b *CircuitBreaker) allow(key string, now time.Time) (uint64, error) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(key, now)
+	if c.state == BreakerOpen {
+		if now.Sub(c.openedAt) < b.policy.CoolDown {
+			return 0, fmt.Errorf("%w: %s", ErrCircuitOpen, key)
+		}
+		changes = append(changes, b.transition(key, c, BreakerHalfOpen, now))
+	}
+	if c.state == BreakerHalfOpen {
+		if c.probes >= b.policy.HalfOpenProbes {
+			return 0, fmt.Errorf("%w: %s", ErrCircuitOpen, key)
+		}
+		c.probes++
+	}
+	return c.generation, nil
+}
+
+// record accounts the outcome of a request admitted at generation
+func (b *CircuitBreaker) record(key string, generation uint64, outcome breakerOutcome, now time.Time) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(key, now)
+	if c.generation != generation {
+		return
+	}
+
+	switch c.state {
+	case BreakerHalfOpen:
+		switch outcome {
+		case breakerFailure:
+			changes = append(changes, b.transition(key, c, BreakerOpen, now))
+		case breakerSuccess:
+			if c.successes++; c.successes >= b.policy.HalfOpenProbes {
+				changes = append(changes, b.transition(key, c, BreakerClosed, now))
+			}
+		default:
+			c.probes--
+		}
+
+	case BreakerClosed:
+		if outcome == breakerIgnored {
+			return
+		}
+		slot := c.slot(now, b.policy.Window)
+		slot.total++
+		if outcome == breakerSuccess {
+			c.consecutive = 0
+			return
+		}
+		slot.failures++
+		c.consecutive++
+
+		if b.tripped(c, now) {
+			changes = append(changes, b.transition(key, c, BreakerOpen, now))
+		}
+	}
+}
+
+func (b *CircuitBreaker) tripped(c *circuit, now time.Time) bool {
+	p := b.policy
+	if p.ConsecutiveFailures > 0 && c.consecutive >= p.ConsecutiveFailures {
+		return true
+	}
+	if p.FailureRate <= 0 {
+		return false
+	}
+	total, failures := c.counts(now, p.Window)
+	return total >= p.MinRequests && float64(failures) >= p.FailureRate*float64(total)
+}
+
+func (b *CircuitBreaker) transition(key string, c *circuit, to BreakerState, now time.Time) stateChange {
+	change := stateChange{key: key, from: c.state, to: to}
+	c.state = to
+	c.generation++
+	c.probes = 0
+	c.successes = 0
+	c.consecutive = 0
+	switch to {
+	case BreakerOpen:
+		c.openedAt = now
+	case BreakerClosed:
+		c.slots = [breakerSlots]breakerSlot{}
+	}
+	return change
+}
+
+func (b *CircuitBreaker) notify(changes []stateChange) {
+	if b.policy.OnStateChange == nil {
+		return
+	}
+	for _, c := range changes {
+		b.policy.OnStateChange(c.key, c.from, c.to)
+	}
+}
+
+// circuit returns the circuit of key, creating it closed
+func (b *CircuitBreaker) circuit(key string, now time.Time) *circuit {
+	c, ok := b.circuits[key]
+	if !ok {
+		if len(b.circuits) >= maxIdleKeys {
+			b.evict(now)
+		}
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+	return c
+}
+
+// evict drops the closed circuits without recent requests
+func (b *CircuitBreaker) evict(now time.Time) {
+	for key, c := range b.circuits {
+		if total, _ := c.counts(now, b.policy.Window); c.state == BreakerClosed && total == 0 {
+			delete(b.circuits, key)
+		}
+	}
+}
+
+// slot returns the window slot now falls in
+func (c *circuit) slot(now time.Time, window time.Duration) *breakerSlot {
+	epoch := slotEpoch(now, window)
+	s := &c.slots[epoch%breakerSlots]
+	if s.epoch != epoch {
+		*s = breakerSlot{epoch: epoch}
+	}
+	return s
+}
+
+// counts sums the requests and failures of the window ending at now
+func (c *circuit) counts(now time.Time, window time.Duration) (total, failures int) {
+	epoch := slotEpoch(now, window)
+	for _, s := range c.slots {
+		if s.epoch > epoch-breakerSlots && s.epoch <= epoch {
+			total += s.total
+			failures += s.failures
+		}
+	}
+	return total, failures
+}
+
+func slotEpoch(now time.Time, window time.Duration) int64 {
+	d := int64(window / breakerSlots)
+	if d <= 0 {
+		d = 1
+	}
+	return now.UnixNano() / d
+}
@@ -0,0 +1,201 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		hits   int32
+		status int32 = http.StatusOK
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	host := ts.Listener.Addr().String()
+
+	type change struct {
+		key      string
+		from, to BreakerState
+	}
+	var (
+		mu      sync.Mutex
+		changes []change
+	)
+	onChange := func(key string, from, to BreakerState) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, change{key, from, to})
+	}
+
+	Convey("Consecutive failures open the circuit", t, func() {
+		changes = nil
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		b := NewCircuitBreaker(BreakerPolicy{
+			ConsecutiveFailures: 3,
+			CoolDown:            50 * time.Millisecond,
+			OnStateChange:       onChange,
+		})
+		r := New(SetBaseURL(ts.URL), SetCircuitBreaker(b))
+
+		for i := 0; i < 3; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, http.StatusServiceUnavailable)
+		}
+		So(b.State(host), ShouldEqual, BreakerOpen)
+
+		atomic.StoreInt32(&hits, 0)
+		_, err := r.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 0)
+
+		Convey("A failed probe opens it again", func() {
+			time.Sleep(60 * time.Millisecond)
+			So(b.State(host), ShouldEqual, BreakerHalfOpen)
+			_, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			So(b.State(host), ShouldEqual, BreakerOpen)
+			So(changes, ShouldResemble, []change{
+				{host, BreakerClosed, BreakerOpen},
+				{host, BreakerOpen, BreakerHalfOpen},
+				{host, BreakerHalfOpen, BreakerOpen},
+			})
+		})
+
+		Convey("A successful probe closes it", func() {
+			atomic.StoreInt32(&status, http.StatusOK)
+			time.Sleep(60 * time.Millisecond)
+			_, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			So(b.State(host), ShouldEqual, BreakerClosed)
+			So(changes, ShouldResemble, []change{
+				{host, BreakerClosed, BreakerOpen},
+				{host, BreakerOpen, BreakerHalfOpen},
+				{host, BreakerHalfOpen, BreakerClosed},
+			})
+		})
+	})
+
+	Convey("Half-open admits a limited number of probes", t, func() {
+		release := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				<-release
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer slow.Close()
+
+		b := NewCircuitBreaker(BreakerPolicy{ConsecutiveFailures: 1, CoolDown: 10 * time.Millisecond})
+		r := New(SetBaseURL(slow.URL), SetCircuitBreaker(b))
+		_, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		time.Sleep(20 * time.Millisecond)
+
+		done := make(chan error)
+		go func() {
+			_, err := r.Get(ctx, "/slow", nil)
+			done <- err
+		}()
+		for b.State(slow.Listener.Addr().String()) != BreakerHalfOpen || probing(b, slow.Listener.Addr().String()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		_, err = r.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+
+		close(release)
+		So(<-done, ShouldBeNil)
+	})
+
+	Convey("Failure rate over the window opens the circuit", t, func() {
+		b := NewCircuitBreaker(BreakerPolicy{FailureRate: 0.5, MinRequests: 4, Window: time.Minute})
+		r := New(SetBaseURL(ts.URL), SetCircuitBreaker(b))
+
+		for _, code := range []int32{http.StatusOK, http.StatusInternalServerError, http.StatusOK} {
+			atomic.StoreInt32(&status, code)
+			_, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+		}
+		So(b.State(host), ShouldEqual, BreakerClosed)
+
+		atomic.StoreInt32(&status, http.StatusBadGateway)
+		_, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		So(b.State(host), ShouldEqual, BreakerOpen)
+	})
+
+	Convey("Transport errors count and circuits are per key", t, func() {
+		b := NewCircuitBreaker(BreakerPolicy{ConsecutiveFailures: 1, CoolDown: time.Minute})
+		r := New(SetCircuitBreaker(b))
+
+		dead := httptest.NewServer(http.NotFoundHandler())
+		deadURL := dead.URL
+		dead.Close()
+
+		_, err := r.Get(ctx, deadURL, nil)
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrCircuitOpen), ShouldBeFalse)
+		_, err = r.Get(ctx, deadURL, nil)
+		So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+
+		atomic.StoreInt32(&status, http.StatusOK)
+		_, err = r.Get(ctx, ts.URL, nil)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Custom key and classification", t, func() {
+		b := NewCircuitBreaker(BreakerPolicy{
+			ConsecutiveFailures: 1,
+			CoolDown:            time.Minute,
+			Key:                 func(req *http.Request) string { return req.URL.Path },
+			IsFailure: func(resp *http.Response, err error) bool {
+				return err != nil || resp.StatusCode == http.StatusNotFound
+			},
+		})
+		r := New(SetBaseURL(ts.URL), SetCircuitBreaker(b))
+
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		_, err := r.Get(ctx, "/a", nil)
+		So(err, ShouldBeNil)
+		So(b.State("/a"), ShouldEqual, BreakerClosed)
+
+		atomic.StoreInt32(&status, http.StatusNotFound)
+		_, err = r.Get(ctx, "/a", url.Values{"q": {"1"}})
+		So(err, ShouldBeNil)
+		So(b.State("/a"), ShouldEqual, BreakerOpen)
+		So(b.State("/b"), ShouldEqual, BreakerClosed)
+	})
+
+	Convey("Open circuits are not retried", t, func() {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL),
+			SetRetry(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}),
+			SetBreaker(BreakerPolicy{ConsecutiveFailures: 2, CoolDown: time.Minute}))
+
+		_, err := r.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 2)
+	})
+}
+
+func probing(b *CircuitBreaker, key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.circuits[key].probes
+}
@@ -0,0 +1,546 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheNow is the clock used by the HTTP cache
+var cacheNow = time.Now
+
+// Limits of the HTTP cache: responses larger than maxCacheBodySize are
+// passed through without being stored, and background revalidations give
+// up after cacheRevalidateTimeout
+var (
+	maxCacheBodySize       int64 = 10 << 20
+	cacheRevalidateTimeout       = 30 * time.Second
+)
+
+// heuristicStatus lists the statuses cacheable without explicit freshness
+// information (RFC 9110 section 15.1)
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// SetCache enables a private HTTP cache following RFC 9111 for GET
+// requests, keeping the responses in store
+func SetCache(store CacheStore) Option {
+	return func(o *options) {
+		o.cache = store
+	}
+}
+
+type httpCache struct {
+	store             CacheStore
+	codecs            map[string]Codec
+	maxBodySize       int64
+	revalidateTimeout time.Duration
+	revalidating      sync.Map
+}
+
+func newHTTPCache(store CacheStore, codecs map[string]Codec) *httpCache {
+	return &httpCache{
+		store:             store,
+		codecs:            codecs,
+		maxBodySize:       maxCacheBodySize,
+		revalidateTimeout: cacheRevalidateTimeout,
+	}
+}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	StatusCode   int               `json:"status"`
+	Header       http.Header       `json:"header"`
+	Body         []byte            `json:"body"`
+	RequestTime  time.Time         `json:"request_time"`
+	ResponseTime time.Time         `json:"response_time"`
+	Vary         map[string]string `json:"vary,omitempty"`
+}
+
+func (c *httpCache) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		if req.Method != http.MethodGet {
+			resp, err := next(ctx, req)
+			if err == nil && !isSafe(req.Method) {
+				c.invalidate(req, resp.Response())
+			}
+			return resp, err
+		}
+
+		reqCC := parseCacheControl(req.Header)
+		if _, ok := reqCC["no-store"]; ok || req.Header.Get(HeaderRange) != "" {
+			return next(ctx, req)
+		}
+
+		key := cacheKey(req.URL)
+		entry := c.load(key, req)
+		if entry != nil {
+			now := cacheNow()
+			if entry.servable(reqCC, now) {
+				return c.response(req, entry, now), nil
+			}
+			if _, ok := reqCC["only-if-cached"]; ok {
+				return c.response(req, entry, now), nil
+			}
+			if entry.staleWithin("stale-while-revalidate", nil, now) {
+				resp := c.response(req, entry, now)
+				c.revalidate(next, req, key, entry)
+				return resp, nil
+			}
+		} else if _, ok := reqCC["only-if-cached"]; ok {
+			return NewResponse(&http.Response{
+				Status:     "504 Gateway Timeout",
+				StatusCode: http.StatusGatewayTimeout,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     make(http.Header),
+				Body:       http.NoBody,
+				Request:    req,
+			}), nil
+		}
+
+		return c.fetch(ctx, next, req, key, entry, reqCC)
+	}
+}
+
+// fetch sends the request, conditionally when an entry exists, and
+// stores or refreshes the entry from the response
+func (c *httpCache) fetch(ctx context.Context, next Handler, req *http.Request, key string, entry *cacheEntry, reqCC map[string]string) (Responser, error) {
+	creq := req
+	if entry != nil {
+		creq = req.Clone(ctx)
+		if etag := entry.Header.Get(HeaderETag); etag != "" {
+			creq.Header.Set(HeaderIfNoneMatch, etag)
+		}
+		if lm := entry.Header.Get(HeaderLastModified); lm != "" {
+			creq.Header.Set(HeaderIfModifiedSince, lm)
+		}
+	}
+
+	reqTime := cacheNow()
+	resp, err := next(ctx, creq)
+	respTime := cacheNow()
+
+	if entry != nil {
+		failed := err != nil
+		if !failed {
+			switch resp.StatusCode() {
+			case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				failed = true
+			}
+		}
+		if failed && entry.staleWithin("stale-if-error", reqCC, respTime) {
+			if resp != nil {
+				drainBody(resp.Response().Body)
+			}
+			return c.response(req, entry, respTime), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := resp.Response()
+	if entry != nil && res.StatusCode == http.StatusNotModified {
+		drainBody(res.Body)
+		entry.refresh(res.Header, reqTime, respTime)
+		c.save(key, entry)
+		return c.response(req, entry, respTime), nil
+	}
+
+	if !storable(req, res, reqCC) || res.ContentLength > c.maxBodySize ||
+		mediaType(res.Header.Get(HeaderContentType)) == MIMETextEventStream {
+		return resp, nil
+	}
+
+	entry = &cacheEntry{
+		StatusCode:   res.StatusCode,
+		Header:       res.Header.Clone(),
+		RequestTime:  reqTime,
+		ResponseTime: respTime,
+	}
+	for _, name := range headerTokens(res.Header, HeaderVary) {
+		if entry.Vary == nil {
+			entry.Vary = make(map[string]string)
+		}
+		name = http.CanonicalHeaderKey(name)
+		entry.Vary[name] = strings.Join(req.Header.Values(name), ", ")
+	}
+	res.Body = &cacheBody{
+		ReadCloser: res.Body,
+		limit:      c.maxBodySize,
+		known:      res.ContentLength >= 0,
+		store: func(body []byte) {
+			entry.Body = body
+			c.save(key, entry)
+		},
+	}
+	return resp, nil
+}
+
+// cacheBody captures a response body as the caller reads it, storing it
+// once read to the end. A body larger than limit is not stored, and one of
+// known length closed early is read to the end first
+type cacheBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	known bool
+	store func(body []byte)
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.store == nil {
+		return n, err
+	}
+	if int64(b.buf.Len()+n) > b.limit {
+		b.store = nil
+		b.buf = bytes.Buffer{}
+		return n, err
+	}
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.store(b.buf.Bytes())
+		b.store = nil
+	}
+	return n, err
+}
+
+func (b *cacheBody) Close() error {
+	if b.store != nil && b.known {
+		io.Copy(ioutil.Discard, b)
+	}
+	return b.ReadCloser.Close()
+}
+
+// revalidate refreshes a stale entry in the background, at most once per
+// key at a time
+func (c *httpCache) revalidate(next Handler, req *http.Request, key string, entry *cacheEntry) {
+	if _, loaded := c.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.revalidateTimeout)
+	breq := req.Clone(ctx)
+	go func() {
+		defer c.revalidating.Delete(key)
+		defer cancel()
+
+		resp, err := c.fetch(ctx, next, breq, key, entry, nil)
+		if err == nil {
+			body := resp.Response().Body
+			io.Copy(ioutil.Discard, body)
+			body.Close()
+		}
+	}()
+}
+
+func (c *httpCache) load(key string, req *http.Request) *cacheEntry {
+	buf, ok := c.store.Get(key)
+	if !ok {
+		return nil
+	}
+
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(buf, entry); err != nil {
+		c.store.Delete(key)
+		return nil
+	}
+	for name, value := range entry.Vary {
+		if name == "*" || strings.Join(req.Header.Values(name), ", ") != value {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (c *httpCache) save(key string, entry *cacheEntry) {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	c.store.Set(key, buf)
+}
+
+// invalidate drops the entries for the target URI of a successful unsafe
+// request and for the URIs in its Location and Content-Location headers
+func (c *httpCache) invalidate(req *http.Request, res *http.Response) {
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return
+	}
+
+	c.store.Delete(cacheKey(req.URL))
+	for _, h := range []string{HeaderLocation, HeaderContentLocation} {
+		v := res.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		u, err := req.URL.Parse(v)
+		if err != nil || u.Host != req.URL.Host {
+			continue
+		}
+		c.store.Delete(cacheKey(u))
+	}
+}
+
+func (c *httpCache) response(req *http.Request, entry *cacheEntry, now time.Time) Responser {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(entry.age(now)/time.Second), 10))
+
+	return &response{
+		resp: &http.Response{
+			Status:        fmt.Sprintf("%d %s", entry.StatusCode, http.StatusText(entry.StatusCode)),
+			StatusCode:    entry.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(entry.Body)),
+			ContentLength: int64(len(entry.Body)),
+			Request:       req,
+		},
+		codecs:    c.codecs,
+		fromCache: true,
+	}
+}
+
+// age computes the current age of the entry (RFC 9111 section 4.2.3)
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	var apparent time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		if d := e.ResponseTime.Sub(date); d > 0 {
+			apparent = d
+		}
+	}
+
+	corrected := e.ResponseTime.Sub(e.RequestTime)
+	if v, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil {
+		corrected += time.Duration(v) * time.Second
+	}
+	if apparent > corrected {
+		corrected = apparent
+	}
+	return corrected + now.Sub(e.ResponseTime)
+}
+
+// lifetime computes the freshness lifetime of the entry (RFC 9111
+// section 4.2.1), using the heuristic of 10% of the time since the last
+// modification when no explicit expiration is given
+func (e *cacheEntry) lifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if d, ok := ccSeconds(cc, "max-age"); ok {
+		return d
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if v := e.Header.Get(HeaderExpires); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	if _, ok := cc["public"]; ok || heuristicStatus[e.StatusCode] {
+		if lm, err := http.ParseTime(e.Header.Get(HeaderLastModified)); err == nil && date.After(lm) {
+			return date.Sub(lm) / 10
+		}
+	}
+	return 0
+}
+
+// servable reports whether the entry can be used without validation
+// given the request cache directives
+func (e *cacheEntry) servable(reqCC map[string]string, now time.Time) bool {
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	age, lifetime := e.age(now), e.lifetime()
+	if d, ok := ccSeconds(reqCC, "max-age"); ok && age > d {
+		return false
+	}
+	if d, ok := ccSeconds(reqCC, "min-fresh"); ok {
+		age += d
+	}
+	if age < lifetime {
+		return true
+	}
+
+	if _, ok := cc["must-revalidate"]; ok {
+		return false
+	}
+	if v, ok := reqCC["max-stale"]; ok {
+		if v == "" {
+			return true
+		}
+		d, ok := ccSeconds(reqCC, "max-stale")
+		return ok && age-lifetime <= d
+	}
+	return false
+}
+
+// staleWithin reports whether the entry is stale by no more than the
+// window given by the directive in the request or the stored response
+// (RFC 5861)
+func (e *cacheEntry) staleWithin(directive string, reqCC map[string]string, now time.Time) bool {
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["must-revalidate"]; ok {
+		return false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+
+	window, ok := ccSeconds(reqCC, directive)
+	if !ok {
+		window, ok = ccSeconds(cc, directive)
+	}
+	return ok && e.age(now)-e.lifetime() <= window
+}
+
+// refresh updates the entry with the headers of a 304 response
+func (e *cacheEntry) refresh(header http.Header, reqTime, respTime time.Time) {
+	for k, v := range header {
+		switch k {
+		case HeaderContentLength, HeaderContentEncoding, HeaderTransferEncoding, HeaderContentRange:
+			continue
+		}
+		e.Header[k] = v
+	}
+	e.RequestTime = reqTime
+	e.ResponseTime = respTime
+}
+
+// storable reports whether a response may be stored (RFC 9111 section 3)
+func storable(req *http.Request, res *http.Response, reqCC map[string]string) bool {
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+
+	if res.StatusCode == http.StatusPartialContent {
+		return false
+	}
+
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	for _, v := range headerTokens(res.Header, HeaderVary) {
+		if v == "*" {
+			return false
+		}
+	}
+	// the store may be shared by clients sending other credentials, so the
+	// responses to authenticated requests are stored only when marked as
+	// shareable (section 3.5)
+	if req.Header.Get(HeaderAuthorization) != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, mustRevalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+
+	if _, ok := cc["max-age"]; ok {
+		return true
+	}
+	if _, ok := cc["public"]; ok {
+		return true
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+	if res.Header.Get(HeaderExpires) != "" {
+		return true
+	}
+	return heuristicStatus[res.StatusCode] &&
+		(res.Header.Get(HeaderETag) != "" || res.Header.Get(HeaderLastModified) != "")
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func cacheKey(u *url.URL) string {
+	k := *u
+	k.Fragment = ""
+	k.RawFragment = ""
+	return k.String()
+}
+
+// parseCacheControl parses the Cache-Control directives of a header,
+// treating "Pragma: no-cache" as "no-cache" when Cache-Control is absent
+func parseCacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	if header == nil {
+		return cc
+	}
+
+	for _, line := range header.Values(HeaderCacheControl) {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value := part, ""
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				name, value = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	if len(cc) == 0 && strings.EqualFold(header.Get(HeaderPragma), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func ccSeconds(cc map[string]string, directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, line := range header.Values(name) {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				tokens = append(tokens, v)
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,264 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	var hits int32
+	var fail int32
+	var hung int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		if r.Method == http.MethodPost {
+			return
+		}
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/etag":
+			w.Header().Set(HeaderCacheControl, "no-cache")
+			w.Header().Set(HeaderETag, `"v1"`)
+			if r.Header.Get(HeaderIfNoneMatch) == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set(HeaderCacheControl, "max-age=60")
+			w.Header().Set(HeaderVary, HeaderAcceptLanguage)
+			fmt.Fprint(w, r.Header.Get(HeaderAcceptLanguage))
+			return
+		case "/stale":
+			w.Header().Set(HeaderCacheControl, "max-age=1, stale-while-revalidate=60, stale-if-error=60")
+		case "/large":
+			w.Header().Set(HeaderCacheControl, "max-age=60")
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, strings.Repeat("x", 64))
+			return
+		case "/hang":
+			w.Header().Set(HeaderCacheControl, "max-age=1, stale-while-revalidate=60")
+			if r.Header.Get(HeaderIfNoneMatch) != "" {
+				<-r.Context().Done()
+				atomic.StoreInt32(&hung, 1)
+				return
+			}
+			w.Header().Set(HeaderETag, `"v1"`)
+		case "/events":
+			w.Header().Set(HeaderCacheControl, "no-cache")
+			w.Header().Set(HeaderContentType, MIMETextEventStream)
+			fmt.Fprintf(w, "data: %d\n\n", n)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		case "/public":
+			w.Header().Set(HeaderCacheControl, "public, max-age=60")
+		case "/nostore":
+			w.Header().Set(HeaderCacheControl, "no-store")
+		default:
+			w.Header().Set(HeaderCacheControl, "max-age=60")
+		}
+		fmt.Fprintf(w, "%d", n)
+	}))
+	defer ts.Close()
+
+	var skew int64
+	cacheNow = func() time.Time { return time.Now().Add(time.Duration(atomic.LoadInt64(&skew))) }
+	defer atomic.StoreInt64(&skew, 0)
+
+	get := func(r Requester, path string, opts ...RequestOption) (string, bool) {
+		resp, err := r.Get(context.Background(), path, nil, opts...)
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		return body, FromCache(resp)
+	}
+
+	for name, store := range map[string]CacheStore{
+		"Memory": NewMemoryCache(10),
+		"Disk":   NewDiskCache(t.TempDir()),
+	} {
+		Convey("Test Cache Fresh "+name, t, func() {
+			atomic.StoreInt32(&hits, 0)
+			r := New(SetBaseURL(ts.URL), SetCache(store))
+
+			body, cached := get(r, "/fresh")
+			So(body, ShouldEqual, "1")
+			So(cached, ShouldBeFalse)
+
+			body, cached = get(r, "/fresh")
+			So(body, ShouldEqual, "1")
+			So(cached, ShouldBeTrue)
+
+			body, _ = get(r, "/fresh", SetHeader(HeaderCacheControl, "no-cache"))
+			So(body, ShouldEqual, "2")
+
+			body, _ = get(r, "/nostore")
+			So(body, ShouldEqual, "3")
+			body, _ = get(r, "/nostore")
+			So(body, ShouldEqual, "4")
+
+			_, err := r.Post(context.Background(), "/fresh", nil)
+			So(err, ShouldBeNil)
+			body, cached = get(r, "/fresh")
+			So(body, ShouldEqual, "6")
+			So(cached, ShouldBeFalse)
+		})
+	}
+
+	Convey("Test Cache Revalidate", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		body, cached := get(r, "/etag")
+		So(body, ShouldEqual, "1")
+		So(cached, ShouldBeFalse)
+
+		body, cached = get(r, "/etag")
+		So(body, ShouldEqual, "1")
+		So(cached, ShouldBeTrue)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 2)
+	})
+
+	Convey("Test Cache Vary", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		body, _ := get(r, "/vary", SetHeader(HeaderAcceptLanguage, "en"))
+		So(body, ShouldEqual, "en")
+		body, cached := get(r, "/vary", SetHeader(HeaderAcceptLanguage, "en"))
+		So(body, ShouldEqual, "en")
+		So(cached, ShouldBeTrue)
+		body, cached = get(r, "/vary", SetHeader(HeaderAcceptLanguage, "fr"))
+		So(body, ShouldEqual, "fr")
+		So(cached, ShouldBeFalse)
+	})
+
+	Convey("Test Cache Stale", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		body, _ := get(r, "/stale")
+		So(body, ShouldEqual, "1")
+
+		atomic.AddInt64(&skew, int64(5*time.Second))
+		body, cached := get(r, "/stale")
+		So(body, ShouldEqual, "1")
+		So(cached, ShouldBeTrue)
+		for i := 0; i < 100 && atomic.LoadInt32(&hits) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		body, cached = get(r, "/stale")
+		So(body, ShouldEqual, "2")
+		So(cached, ShouldBeTrue)
+
+		atomic.AddInt64(&skew, int64(5*time.Second))
+		atomic.StoreInt32(&fail, 1)
+		defer atomic.StoreInt32(&fail, 0)
+		body, cached = get(r, "/stale", SetHeader(HeaderCacheControl, "no-cache"))
+		So(body, ShouldEqual, "2")
+		So(cached, ShouldBeTrue)
+	})
+
+	Convey("Test Cache Large Response", t, func() {
+		defer func(n int64) { maxCacheBodySize = n }(maxCacheBodySize)
+		maxCacheBodySize = 16
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		body, cached := get(r, "/large")
+		So(body, ShouldEqual, strings.Repeat("x", 64))
+		So(cached, ShouldBeFalse)
+		body, cached = get(r, "/large")
+		So(body, ShouldEqual, strings.Repeat("x", 64))
+		So(cached, ShouldBeFalse)
+	})
+
+	Convey("Test Cache Authorization", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+		alice := r.With(SetBaseHeader(HeaderAuthorization, "Bearer alice"))
+		bob := r.With(SetBaseHeader(HeaderAuthorization, "Bearer bob"))
+
+		body, _ := get(alice, "/fresh")
+		So(body, ShouldEqual, "1")
+		body, cached := get(bob, "/fresh")
+		So(body, ShouldEqual, "2")
+		So(cached, ShouldBeFalse)
+
+		// unless the response is explicitly shareable
+		body, _ = get(alice, "/public")
+		So(body, ShouldEqual, "3")
+		body, cached = get(bob, "/public")
+		So(body, ShouldEqual, "3")
+		So(cached, ShouldBeTrue)
+	})
+
+	Convey("Test Cache Streams", t, func() {
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		errStop := errors.New("stop")
+		var events []Event
+		err := r.Events(ctx, "/events", func(e Event) error {
+			events = append(events, e)
+			return errStop
+		})
+		So(err, ShouldEqual, errStop)
+		So(events, ShouldHaveLength, 1)
+	})
+
+	Convey("Test Cache Revalidation Timeout", t, func() {
+		defer func(d time.Duration) { cacheRevalidateTimeout = d }(cacheRevalidateTimeout)
+		cacheRevalidateTimeout = 20 * time.Millisecond
+		defer atomic.StoreInt64(&skew, atomic.LoadInt64(&skew))
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		get(r, "/hang")
+		atomic.AddInt64(&skew, int64(5*time.Second))
+		_, cached := get(r, "/hang")
+		So(cached, ShouldBeTrue)
+		for i := 0; i < 100 && atomic.LoadInt32(&hung) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(atomic.LoadInt32(&hung), ShouldEqual, 1)
+	})
+
+	Convey("Test Cache Only If Cached", t, func() {
+		r := New(SetBaseURL(ts.URL), SetCache(NewMemoryCache(10)))
+
+		resp, err := r.Get(context.Background(), "/missing", nil, SetHeader(HeaderCacheControl, "only-if-cached"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusGatewayTimeout)
+	})
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	Convey("Test Memory Cache Eviction", t, func() {
+		c := NewMemoryCache(2)
+		c.Set("a", []byte("1"))
+		c.Set("b", []byte("2"))
+		c.Get("a")
+		c.Set("c", []byte("3"))
+
+		_, ok := c.Get("b")
+		So(ok, ShouldBeFalse)
+		v, ok := c.Get("a")
+		So(ok, ShouldBeTrue)
+		So(string(v), ShouldEqual, "1")
+	})
+}
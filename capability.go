@@ -0,0 +1,111 @@
+package req
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Capabilities is what a server advertises in the headers of a response to
+// an OPTIONS request
+type Capabilities struct {
+	Allow       []string // methods of the Allow header
+	AcceptPatch []string // media types of the Accept-Patch header
+	DAV         []string // WebDAV compliance classes of the DAV header
+	CORS        CORS
+}
+
+// CORS holds the CORS headers of a response, a preflight one in particular
+type CORS struct {
+	AllowOrigin      string
+	AllowMethods     []string
+	AllowHeaders     []string
+	AllowCredentials bool
+	ExposeHeaders    []string
+	MaxAge           time.Duration
+}
+
+// ParseCapabilities parses the capabilities advertised in header
+func ParseCapabilities(header http.Header) *Capabilities {
+	c := &Capabilities{
+		Allow:       headerTokens(header, HeaderAllow),
+		AcceptPatch: headerTokens(header, HeaderAcceptPatch),
+		DAV:         headerTokens(header, HeaderDAV),
+		CORS: CORS{
+			AllowOrigin:      header.Get(HeaderAccessControlAllowOrigin),
+			AllowMethods:     headerTokens(header, HeaderAccessControlAllowMethods),
+			AllowHeaders:     headerTokens(header, HeaderAccessControlAllowHeaders),
+			AllowCredentials: header.Get(HeaderAccessControlAllowCredentials) == "true",
+			ExposeHeaders:    headerTokens(header, HeaderAccessControlExposeHeaders),
+		},
+	}
+	if secs, err := strconv.Atoi(header.Get(HeaderAccessControlMaxAge)); err == nil && secs > 0 {
+		c.CORS.MaxAge = time.Duration(secs) * time.Second
+	}
+	return c
+}
+
+// Allows reports whether method is listed in the Allow header
+func (c *Capabilities) Allows(method string) bool {
+	return containsToken(c.Allow, method, false)
+}
+
+// AllowsMethod reports whether a cross-origin request may use method, the
+// CORS-safelisted GET, HEAD and POST always being allowed
+func (c CORS) AllowsMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		return true
+	}
+	return containsToken(c.AllowMethods, method, false) ||
+		(!c.AllowCredentials && containsToken(c.AllowMethods, "*", false))
+}
+
+// AllowsHeader reports whether a cross-origin request may carry the header
+// name
+func (c CORS) AllowsHeader(name string) bool {
+	return containsToken(c.AllowHeaders, name, true) ||
+		(!c.AllowCredentials && containsToken(c.AllowHeaders, "*", false))
+}
+
+// SetPreflight makes the request a CORS preflight one for a request from
+// origin using method and carrying the headers
+func SetPreflight(origin, method string, headers ...string) RequestOption {
+	return func(o *requestOptions) {
+		o.request.Header.Set(HeaderOrigin, origin)
+		o.request.Header.Set(HeaderAccessControlRequestMethod, method)
+		if len(headers) > 0 {
+			o.request.Header.Set(HeaderAccessControlRequestHeaders, strings.Join(headers, ", "))
+		}
+	}
+}
+
+// Capabilities sends an OPTIONS request and parses the capabilities the
+// response advertises, a non-2xx status being reported as a *StatusError
+func (r *request) Capabilities(ctx context.Context, urlStr string, opts ...RequestOption) (*Capabilities, error) {
+	resp, err := r.Options(ctx, urlStr, append(opts[:len(opts):len(opts)], SetStatusError(true))...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	return ParseCapabilities(resp.Response().Header), nil
+}
+
+func (r *request) Options(ctx context.Context, urlStr string, opts ...RequestOption) (Responser, error) {
+	return r.Do(ctx, urlStr, http.MethodOptions, nil, opts...)
+}
+
+func (r *request) Trace(ctx context.Context, urlStr string, opts ...RequestOption) (Responser, error) {
+	return r.Do(ctx, urlStr, http.MethodTrace, nil, opts...)
+}
+
+func containsToken(tokens []string, s string, fold bool) bool {
+	for _, t := range tokens {
+		if t == s || (fold && strings.EqualFold(t, s)) {
+			return true
+		}
+	}
+	return false
+}
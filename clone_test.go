@@ -0,0 +1,65 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWith(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/login") {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+			return
+		}
+		var session string
+		if c, err := r.Cookie("session"); err == nil {
+			session = c.Value
+		}
+		fmt.Fprintf(w, "%s %s %s %s", r.URL.Path, r.Header.Get("X-Tenant"), r.Header.Get("X-Trace"), session)
+	}))
+	defer ts.Close()
+
+	trace := func(next Handler) Handler {
+		return func(ctx context.Context, req *http.Request) (Responser, error) {
+			req.Header.Set("X-Trace", "on")
+			return next(ctx, req)
+		}
+	}
+
+	get := func(r Requester, path string) string {
+		resp, err := r.Get(context.Background(), path, nil)
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		return body
+	}
+
+	Convey("Test With", t, func() {
+		jar, _ := cookiejar.New(nil)
+		parent := New(SetBaseURL(ts.URL+"/a"), SetBaseHeader("X-Tenant", "t1"), SetCookieJar(jar), Use(trace))
+		child := parent.With(SetBaseURL(ts.URL+"/b"), SetBaseHeader("X-Tenant", "t2"), SetTimeout(time.Second), UseOnly())
+
+		So(child.(*request).cli.Transport, ShouldEqual, parent.(*request).cli.Transport)
+		So(child.(*request).cli.Timeout, ShouldEqual, time.Second)
+		So(parent.(*request).cli.Timeout, ShouldEqual, 0)
+
+		resp, err := parent.Get(context.Background(), "/login", nil)
+		So(err, ShouldBeNil)
+		resp.Close()
+
+		So(get(parent, "/x"), ShouldEqual, "/a/x t1 on s1")
+		So(get(child, "/x"), ShouldEqual, "/b/x t2  s1")
+
+		grandchild := child.With(Use(trace))
+		So(get(grandchild, "/x"), ShouldEqual, "/b/x t2 on s1")
+		So(get(child, "/x"), ShouldEqual, "/b/x t2  s1")
+	})
+}
@@ -0,0 +1,138 @@
+package req
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// ErrNoCodec is returned when no codec is registered for a content type
+var ErrNoCodec = errors.New("req: no codec registered for content type")
+
+// Codec encodes and decodes bodies of a given content type
+type Codec interface {
+	// ContentType returns the MIME type sent with encoded bodies
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs
+var (
+	JSONCodec Codec = jsonCodec{}
+	XMLCodec  Codec = xmlCodec{}
+	FormCodec Codec = formCodec{}
+)
+
+var defaultCodecs = map[string]Codec{
+	MIMEApplicationJSON: JSONCodec,
+	MIMEApplicationXML:  XMLCodec,
+	"text/xml":          XMLCodec,
+	MIMEApplicationForm: FormCodec,
+}
+
+// SetCodec registers codecs on the client, keyed on the media type of
+// their ContentType. It replaces a built-in codec of the same type and is
+// how protobuf or msgpack support is plugged in
+func SetCodec(codecs ...Codec) Option {
+	return func(o *options) {
+		m := make(map[string]Codec, len(o.codecs)+len(codecs))
+		for k, c := range o.codecs {
+			m[k] = c
+		}
+		for _, c := range codecs {
+			m[mediaType(c.ContentType())] = c
+		}
+		o.codecs = m
+	}
+}
+
+// lookupCodec finds the codec for contentType among the registered and
+// the built-in codecs, falling back to JSON and XML for "+json" and
+// "+xml" structured syntax suffixes
+func lookupCodec(codecs map[string]Codec, contentType string) (Codec, error) {
+	mt := mediaType(contentType)
+	if c, ok := codecs[mt]; ok {
+		return c, nil
+	}
+	if c, ok := defaultCodecs[mt]; ok {
+		return c, nil
+	}
+
+	switch {
+	case strings.HasSuffix(mt, "+json"):
+		return lookupCodec(codecs, MIMEApplicationJSON)
+	case strings.HasSuffix(mt, "+xml"):
+		return lookupCodec(codecs, MIMEApplicationXML)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoCodec, contentType)
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return MIMEApplicationJSONCharsetUTF8 }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string { return MIMEApplicationXMLCharsetUTF8 }
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// formCodec encodes url.Values, map[string][]string and map[string]string
+// and decodes into *url.Values or *map[string][]string
+type formCodec struct{}
+
+func (formCodec) ContentType() string { return MIMEApplicationForm }
+
+func (formCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case url.Values:
+		return []byte(v.Encode()), nil
+	case *url.Values:
+		return []byte(v.Encode()), nil
+	case map[string][]string:
+		return []byte(url.Values(v).Encode()), nil
+	case map[string]string:
+		values := make(url.Values, len(v))
+		for k, s := range v {
+			values.Set(k, s)
+		}
+		return []byte(values.Encode()), nil
+	}
+	return nil, fmt.Errorf("req: cannot form encode %T", v)
+}
+
+func (formCodec) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case *url.Values:
+		*v = values
+	case *map[string][]string:
+		*v = values
+	default:
+		return fmt.Errorf("req: cannot form decode into %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// upperCodec is a toy codec standing in for protobuf or msgpack
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return MIMEApplicationMsgpack }
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/form":
+			w.Header().Set(HeaderContentType, MIMEApplicationForm)
+			fmt.Fprint(w, "a=1&b=2")
+		case "/unknown":
+			w.Header().Set(HeaderContentType, "application/x-unknown")
+			fmt.Fprint(w, "???")
+		default:
+			w.Header().Set(HeaderContentType, r.Header.Get(HeaderContentType))
+			w.Write(body)
+		}
+	}))
+	defer ts.Close()
+
+	type item struct {
+		Name string `xml:"name"`
+	}
+
+	Convey("Test Codec Built-in", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		v, _, err := PostAs[item](context.Background(), r, "/echo", MIMEApplicationXML, item{Name: "foo"})
+		So(err, ShouldBeNil)
+		So(v.Name, ShouldEqual, "foo")
+
+		resp, err := r.Get(context.Background(), "/form", nil)
+		So(err, ShouldBeNil)
+		var values url.Values
+		So(Decode(resp, &values), ShouldBeNil)
+		So(values.Get("b"), ShouldEqual, "2")
+
+		// responses of other implementations use the built-in codecs
+		resp, err = r.Get(context.Background(), "/form", nil)
+		So(err, ShouldBeNil)
+		custom := struct{ Responser }{resp}
+		values = nil
+		So(Decode(custom, &values), ShouldBeNil)
+		So(values.Get("b"), ShouldEqual, "2")
+		So(FromCache(custom), ShouldBeFalse)
+		So(Attempt(custom), ShouldEqual, 1)
+
+		resp, err = r.Get(context.Background(), "/unknown", nil)
+		So(err, ShouldBeNil)
+		So(errors.Is(Decode(resp, &values), ErrNoCodec), ShouldBeTrue)
+
+		_, err = r.(EncodedRequester).DoEncoded(context.Background(), "/echo", http.MethodPost, MIMEApplicationMsgpack, "foo")
+		So(errors.Is(err, ErrNoCodec), ShouldBeTrue)
+
+		// requesters not encoding bodies themselves use the built-in codecs
+		wrapped := struct{ Requester }{r}
+		v, _, err = PostAs[item](context.Background(), wrapped, "/echo", MIMEApplicationXML, item{Name: "bar"})
+		So(err, ShouldBeNil)
+		So(v.Name, ShouldEqual, "bar")
+	})
+
+	Convey("Test Codec Registered", t, func() {
+		r := New(SetBaseURL(ts.URL), SetCodec(upperCodec{}))
+
+		resp, err := r.(EncodedRequester).DoEncoded(context.Background(), "/echo", http.MethodPut, MIMEApplicationMsgpack, "foo")
+		So(err, ShouldBeNil)
+		So(resp.Response().Header.Get(HeaderContentType), ShouldEqual, MIMEApplicationMsgpack)
+
+		var s string
+		So(Decode(resp, &s), ShouldBeNil)
+		So(s, ShouldEqual, "foo")
+
+		v, _, err := PutAs[string](context.Background(), r, "/echo", MIMEApplicationMsgpack, "bar")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "bar")
+	})
+}
@@ -0,0 +1,371 @@
+package req
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrConcurrencyLimited is returned when a request is not sent because too
+// many requests are in flight, either failing fast or the queue being full
+var ErrConcurrencyLimited = errors.New("req: concurrency limited")
+
+// Default concurrency limiter settings
+const (
+	DefaultConcurrencyLimit     = 20
+	DefaultConcurrencyMinLimit  = 1
+	DefaultConcurrencyMaxLimit  = 200
+	DefaultConcurrencyBackoff   = 0.9
+	DefaultConcurrencyTolerance = 1.5
+)
+
+// gradientSmoothing is the weight of each new limit computed by
+// GradientLimit, and gradientRTTWeight that of each latency sample in the
+// long term average
+const (
+	gradientSmoothing = 0.2
+	gradientRTTWeight = 0.05
+)
+
+// LimitAlgorithm is how a concurrency limiter adjusts its limit
+type LimitAlgorithm int
+
+// Limit algorithms
+const (
+	// FixedLimit keeps the limit to ConcurrencyPolicy.Limit
+	FixedLimit LimitAlgorithm = iota
+	// AIMDLimit raises the limit by one every limit successful requests
+	// and multiplies it by Backoff on each failure
+	AIMDLimit
+	// GradientLimit follows the ratio between the long term average
+	// latency and the latency of each request, shrinking the limit as the
+	// latency grows and probing for more otherwise. Failures are handled
+	// as with AIMDLimit
+	GradientLimit
+)
+
+func (a LimitAlgorithm) String() string {
+	switch a {
+	case FixedLimit:
+		return "fixed"
+	case AIMDLimit:
+		return "aimd"
+	case GradientLimit:
+		return "gradient"
+	}
+	return fmt.Sprintf("LimitAlgorithm(%d)", int(a))
+}
+
+// ConcurrencyPolicy describes how many requests may be in flight at once
+type ConcurrencyPolicy struct {
+	Algorithm LimitAlgorithm
+	// Limit is the initial number of requests in flight for each key,
+	// DefaultConcurrencyLimit by default
+	Limit int
+	// MinLimit and MaxLimit bound the limits, including the initial one
+	MinLimit int
+	MaxLimit int
+	// MaxQueue is the number of requests that may wait for a slot before
+	// failing with ErrConcurrencyLimited, 0 leaving the queue unbounded
+	MaxQueue int
+	// FailFast returns ErrConcurrencyLimited instead of waiting for a slot
+	FailFast bool
+	// Backoff is the factor the limit is multiplied by on failures,
+	// DefaultConcurrencyBackoff by default
+	Backoff float64
+	// Timeout, when set, makes AIMDLimit handle the requests slower than
+	// it as failures
+	Timeout time.Duration
+	// Tolerance is how much GradientLimit lets the latency grow over its
+	// long term average before shrinking the limit,
+	// DefaultConcurrencyTolerance by default
+	Tolerance float64
+	// Key maps a request to its limit, all the requests of the client
+	// sharing one by default. Returning req.URL.Host limits each host
+	Key func(req *http.Request) string
+	// IsFailure classifies the outcome of a request, by default errors
+	// other than a canceled context and 429, 503 and 504 responses being
+	// failures
+	IsFailure func(resp *http.Response, err error) bool
+}
+
+// ConcurrencyStats is the state of a concurrency limit
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+}
+
+// ConcurrencyLimiter bounds the number of requests in flight per key,
+// queueing the others in order. A request is in flight until its response
+// body is closed. It can be shared by several clients
+type ConcurrencyLimiter struct {
+	policy ConcurrencyPolicy
+
+	mu     sync.Mutex
+	limits map[string]*concurrencyLimit
+}
+
+type concurrencyLimit struct {
+	limit    float64
+	inFlight int
+	// queue holds the chan of each waiting request, closed once it is
+	// granted a slot
+	queue   *list.List
+	longRTT time.Duration
+}
+
+// NewConcurrencyLimiter create a concurrency limiter
+func NewConcurrencyLimiter(policy ConcurrencyPolicy) *ConcurrencyLimiter {
+	if policy.Limit <= 0 {
+		policy.Limit = DefaultConcurrencyLimit
+	}
+	if policy.MinLimit <= 0 {
+		policy.MinLimit = DefaultConcurrencyMinLimit
+	}
+	if policy.MaxLimit <= 0 {
+		policy.MaxLimit = DefaultConcurrencyMaxLimit
+	}
+	if policy.MaxLimit < policy.MinLimit {
+		policy.MaxLimit = policy.MinLimit
+	}
+	if policy.Limit < policy.MinLimit {
+		policy.Limit = policy.MinLimit
+	}
+	if policy.Limit > policy.MaxLimit {
+		policy.Limit = policy.MaxLimit
+	}
+	if policy.Backoff <= 0 || policy.Backoff >= 1 {
+		policy.Backoff = DefaultConcurrencyBackoff
+	}
+	if policy.Tolerance < 1 {
+		policy.Tolerance = DefaultConcurrencyTolerance
+	}
+	return &ConcurrencyLimiter{
+		policy: policy,
+		limits: make(map[string]*concurrencyLimit),
+	}
+}
+
+// SetConcurrencyLimiter bounds the requests in flight through l
+func SetConcurrencyLimiter(l *ConcurrencyLimiter) Option {
+	return func(o *options) {
+		o.concurrency = l
+	}
+}
+
+// SetConcurrencyLimit bounds the requests in flight according to the
+// policy, with a concurrency limiter of its own
+func SetConcurrencyLimit(policy ConcurrencyPolicy) Option {
+	return SetConcurrencyLimiter(NewConcurrencyLimiter(policy))
+}
+
+// Stats returns the state of the limit of each key
+func (l *ConcurrencyLimiter) Stats() map[string]ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make(map[string]ConcurrencyStats, len(l.limits))
+	for key, c := range l.limits {
+		stats[key] = ConcurrencyStats{Limit: c.current(), InFlight: c.inFlight, Queued: c.queue.Len()}
+	}
+	return stats
+}
+
+func (l *ConcurrencyLimiter) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		key := l.key(req)
+		if err := l.acquire(ctx, key); err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := next(ctx, req)
+		rtt := time.Since(start)
+		var res *http.Response
+		if err == nil {
+			res, err = httpResponse(resp)
+		}
+		if err != nil {
+			l.release(key, l.failed(nil, err), rtt, err)
+			return nil, err
+		}
+
+		// the slot is held until the body is closed, the latency being
+		// measured up to the response headers
+		failed := l.failed(res, nil)
+		if res.Body == nil {
+			l.release(key, failed, rtt, nil)
+		} else {
+			var once sync.Once
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: func() {
+				once.Do(func() { l.release(key, failed, rtt, nil) })
+			}}
+		}
+		return resp, nil
+	}
+}
+
+func (l *ConcurrencyLimiter) key(req *http.Request) string {
+	if l.policy.Key != nil {
+		return l.policy.Key(req)
+	}
+	return ""
+}
+
+func (l *ConcurrencyLimiter) failed(res *http.Response, err error) bool {
+	if l.policy.IsFailure != nil {
+		return l.policy.IsFailure(res, err)
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// acquire takes a slot of key, waiting in the queue for one if needed
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, key string) error {
+	l.mu.Lock()
+	c := l.limit(key)
+	if c.queue.Len() == 0 && c.inFlight < c.current() {
+		c.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.policy.FailFast {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: %q, %d requests in flight", ErrConcurrencyLimited, key, c.inFlight)
+	}
+	if l.policy.MaxQueue > 0 && c.queue.Len() >= l.policy.MaxQueue {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: %q, %d requests queued", ErrConcurrencyLimited, key, c.queue.Len())
+	}
+	ready := make(chan struct{})
+	elem := c.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// granted meanwhile, the slot goes to the next request
+		c.inFlight--
+		l.grant(c)
+	default:
+		c.queue.Remove(elem)
+	}
+	return ctx.Err()
+}
+
+// release gives back a slot of key, adjusting the limit to the outcome
+func (l *ConcurrencyLimiter) release(key string, failed bool, rtt time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.limits[key]
+	inFlight := c.inFlight
+	c.inFlight--
+	if !errors.Is(err, context.Canceled) {
+		l.adjust(c, failed, rtt, inFlight)
+	}
+	l.grant(c)
+
+	if len(l.limits) > maxIdleKeys {
+		l.evict()
+	}
+}
+
+// adjust updates the limit of c after a request sent while inFlight
+// requests were
+func (l *ConcurrencyLimiter) adjust(c *concurrencyLimit, failed bool, rtt time.Duration, inFlight int) {
+	p := l.policy
+	if p.Algorithm == FixedLimit {
+		return
+	}
+	if p.Algorithm == AIMDLimit && p.Timeout > 0 && rtt > p.Timeout {
+		failed = true
+	}
+
+	limit := c.limit
+	// the limit only grows when it is actually reached
+	saturated := float64(inFlight)*2 >= c.limit
+	switch {
+	case failed:
+		limit *= p.Backoff
+	case p.Algorithm == AIMDLimit:
+		if saturated {
+			limit += 1 / limit
+		}
+	case p.Algorithm == GradientLimit:
+		if c.longRTT == 0 {
+			c.longRTT = rtt
+		} else {
+			c.longRTT += time.Duration(gradientRTTWeight * float64(rtt-c.longRTT))
+		}
+		// lets the average recover after a long spell of high latency
+		if c.longRTT > 2*rtt {
+			c.longRTT = c.longRTT * 95 / 100
+		}
+
+		gradient := 1.0
+		if rtt > 0 {
+			gradient = math.Max(0.5, math.Min(1, p.Tolerance*float64(c.longRTT)/float64(rtt)))
+		}
+		next := limit*gradient + math.Sqrt(limit)
+		if !saturated && next > limit {
+			next = limit
+		}
+		limit = limit*(1-gradientSmoothing) + next*gradientSmoothing
+	}
+	c.limit = math.Max(float64(p.MinLimit), math.Min(float64(p.MaxLimit), limit))
+}
+
+// grant hands the free slots of c to the requests queued first
+func (l *ConcurrencyLimiter) grant(c *concurrencyLimit) {
+	for c.queue.Len() > 0 && c.inFlight < c.current() {
+		ready := c.queue.Remove(c.queue.Front()).(chan struct{})
+		c.inFlight++
+		close(ready)
+	}
+}
+
+// limit returns the limit of key
+func (l *ConcurrencyLimiter) limit(key string) *concurrencyLimit {
+	c, ok := l.limits[key]
+	if !ok {
+		c = &concurrencyLimit{limit: float64(l.policy.Limit), queue: list.New()}
+		l.limits[key] = c
+	}
+	return c
+}
+
+// evict drops the idle limits
+func (l *ConcurrencyLimiter) evict() {
+	for key, c := range l.limits {
+		if c.inFlight == 0 && c.queue.Len() == 0 {
+			delete(l.limits, key)
+		}
+	}
+}
+
+func (c *concurrencyLimit) current() int {
+	if n := int(c.limit); n > 1 {
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,253 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	var (
+		inFlight, peak int32
+		unblock        = make(chan struct{})
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		switch r.URL.Path {
+		case "/block":
+			<-unblock
+		case "/sleep":
+			ms, _ := strconv.Atoi(r.URL.Query().Get("ms"))
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+		case "/status":
+			code, _ := strconv.Atoi(r.URL.Query().Get("code"))
+			w.WriteHeader(code)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	waitStats := func(l *ConcurrencyLimiter, want ConcurrencyStats) {
+		for i := 0; i < 200 && l.Stats()[""] != want; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		So(l.Stats()[""], ShouldResemble, want)
+	}
+
+	Convey("Queues requests beyond the limit", t, func() {
+		atomic.StoreInt32(&peak, 0)
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{Limit: 2})
+		r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := r.Get(ctx, "/block", nil)
+				if err == nil {
+					resp.Close()
+				}
+				errs <- err
+			}()
+		}
+		waitStats(l, ConcurrencyStats{Limit: 2, InFlight: 2, Queued: 3})
+
+		for i := 0; i < 5; i++ {
+			unblock <- struct{}{}
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+		So(atomic.LoadInt32(&peak), ShouldEqual, 2)
+		So(l.Stats()[""], ShouldResemble, ConcurrencyStats{Limit: 2})
+	})
+
+	Convey("Bounds the initial limit", t, func() {
+		for _, tc := range []struct {
+			policy ConcurrencyPolicy
+			limit  int
+		}{
+			{ConcurrencyPolicy{Limit: 500}, DefaultConcurrencyMaxLimit},
+			{ConcurrencyPolicy{Limit: 2, MinLimit: 5}, 5},
+			{ConcurrencyPolicy{Algorithm: AIMDLimit, Limit: 50, MaxLimit: 10}, 10},
+		} {
+			l := NewConcurrencyLimiter(tc.policy)
+			resp, err := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l)).Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			So(l.Stats()[""].Limit, ShouldEqual, tc.limit)
+			resp.Close()
+		}
+	})
+
+	Convey("Holds the slot until the body is closed", t, func() {
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{Limit: 1, FailFast: true})
+		r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		_, err = r.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrConcurrencyLimited), ShouldBeTrue)
+
+		resp.Close()
+		resp, err = r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		resp.Close()
+	})
+
+	Convey("Bounds the queue", t, func() {
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{Limit: 1, MaxQueue: 1})
+		r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+
+		first, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		done := make(chan error, 1)
+		go func() {
+			resp, err := r.Get(ctx, "/", nil)
+			if err == nil {
+				resp.Close()
+			}
+			done <- err
+		}()
+		waitStats(l, ConcurrencyStats{Limit: 1, InFlight: 1, Queued: 1})
+
+		_, err = r.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrConcurrencyLimited), ShouldBeTrue)
+
+		first.Close()
+		So(<-done, ShouldBeNil)
+	})
+
+	Convey("Stops waiting when the context is done", t, func() {
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{Limit: 1})
+		r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+
+		first, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+
+		tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = r.Get(tctx, "/", nil)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(l.Stats()[""], ShouldResemble, ConcurrencyStats{Limit: 1, InFlight: 1})
+
+		first.Close()
+		So(l.Stats()[""], ShouldResemble, ConcurrencyStats{Limit: 1})
+	})
+
+	Convey("Limits each host", t, func() {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer other.Close()
+
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{
+			Limit:    1,
+			FailFast: true,
+			Key:      func(req *http.Request) string { return req.URL.Host },
+		})
+		r := New(SetConcurrencyLimiter(l))
+
+		first, err := r.Get(ctx, ts.URL, nil)
+		So(err, ShouldBeNil)
+		defer first.Close()
+		resp, err := r.Get(ctx, other.URL, nil)
+		So(err, ShouldBeNil)
+		resp.Close()
+		_, err = r.Get(ctx, ts.URL, nil)
+		So(errors.Is(err, ErrConcurrencyLimited), ShouldBeTrue)
+
+		stats := l.Stats()
+		So(stats[ts.Listener.Addr().String()].InFlight, ShouldEqual, 1)
+		So(stats[other.Listener.Addr().String()].InFlight, ShouldEqual, 0)
+	})
+
+	Convey("AIMD", t, func() {
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{Algorithm: AIMDLimit, Limit: 10, Timeout: 50 * time.Millisecond})
+		r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+
+		// overload responses and slow requests shrink the limit
+		for i := 0; i < 4; i++ {
+			resp, err := r.Get(ctx, "/status", map[string][]string{"code": {"503"}})
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		resp, err := r.Get(ctx, "/sleep", map[string][]string{"ms": {"60"}})
+		So(err, ShouldBeNil)
+		resp.Close()
+		So(l.Stats()[""].Limit, ShouldEqual, 5)
+
+		// successes grow it back only while it is reached
+		for i := 0; i < 5; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		So(l.Stats()[""].Limit, ShouldEqual, 5)
+
+		for i := 0; i < 3; i++ {
+			held, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			defer held.Close()
+		}
+		for i := 0; i < 10; i++ {
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+		So(l.Stats()[""].Limit, ShouldBeGreaterThan, 5)
+	})
+
+	Convey("Gradient", t, func() {
+		l := NewConcurrencyLimiter(ConcurrencyPolicy{Algorithm: GradientLimit, Limit: 20})
+		r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+		sleep := func(ms string) {
+			resp, err := r.Get(ctx, "/sleep", map[string][]string{"ms": {ms}})
+			So(err, ShouldBeNil)
+			resp.Close()
+		}
+
+		for i := 0; i < 5; i++ {
+			sleep("5")
+		}
+		So(l.Stats()[""].Limit, ShouldEqual, 20)
+
+		// the latency growing well past its average shrinks the limit
+		for i := 0; i < 3; i++ {
+			sleep("60")
+		}
+		So(l.Stats()[""].Limit, ShouldBeLessThan, 20)
+
+		Convey("while a steady latency lets a reached limit grow", func() {
+			l := NewConcurrencyLimiter(ConcurrencyPolicy{Algorithm: GradientLimit, Limit: 2})
+			r := New(SetBaseURL(ts.URL), SetConcurrencyLimiter(l))
+
+			held, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			defer held.Close()
+			for i := 0; i < 5; i++ {
+				resp, err := r.Get(ctx, "/sleep", map[string][]string{"ms": {"5"}})
+				So(err, ShouldBeNil)
+				resp.Close()
+			}
+			So(l.Stats()[""].Limit, ShouldBeGreaterThan, 2)
+		})
+	})
+}
@@ -2,26 +2,111 @@ package req
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/url"
 	"sync"
+	"sync/atomic"
 )
 
+// ErrDefaultInitialized is returned by SetOptions once the default
+// client has been created, use Reconfigure to replace it
+var ErrDefaultInitialized = errors.New("req: default client already initialized")
+
 var (
-	internalReq Requester
-	once        sync.Once
+	defaultMu  sync.Mutex
+	defaultReq atomic.Value // defaultHolder
+
+	clientsMu sync.RWMutex
+	clients   = make(map[string]Requester)
 )
 
-func req(opt ...Option) Requester {
-	once.Do(func() {
-		internalReq = New(opt...)
-	})
-	return internalReq
+// defaultHolder keeps atomic.Value storing a single concrete type
+type defaultHolder struct {
+	r Requester
 }
 
-// SetOptions set the parameter options
-func SetOptions(opt ...Option) {
-	req(opt...)
+func req() Requester {
+	if h, ok := defaultReq.Load().(defaultHolder); ok {
+		return h.r
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if h, ok := defaultReq.Load().(defaultHolder); ok {
+		return h.r
+	}
+	r := New()
+	defaultReq.Store(defaultHolder{r})
+	return r
+}
+
+// SetOptions set the parameter options of the default client. The options
+// only apply before the default client is first used, ErrDefaultInitialized
+// is returned afterwards and the options are ignored
+func SetOptions(opt ...Option) error {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultReq.Load() != nil {
+		return ErrDefaultInitialized
+	}
+	defaultReq.Store(defaultHolder{New(opt...)})
+	return nil
+}
+
+// Reconfigure atomically replaces the default client with a new one
+// created from opt. Requests in flight finish with the previous client
+func Reconfigure(opt ...Option) Requester {
+	r := New(opt...)
+	SetDefault(r)
+	return r
+}
+
+// SetDefault atomically replaces the default client, a nil r resets it
+// to a client with the default options
+func SetDefault(r Requester) {
+	if r == nil {
+		r = New()
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultReq.Store(defaultHolder{r})
+}
+
+// Default returns the default client used by the package-level functions
+func Default() Requester {
+	return req()
+}
+
+// With derives a client from the default client overriding the given options
+func With(opt ...Option) Requester {
+	return req().With(opt...)
+}
+
+// Register registers r under name, replacing any client registered with
+// the same name. A nil r removes the client
+func Register(name string, r Requester) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if r == nil {
+		delete(clients, name)
+		return
+	}
+	clients[name] = r
+}
+
+// Client returns the client registered under name
+func Client(name string) (Requester, bool) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	r, ok := clients[name]
+	return r, ok
 }
 
 // Head head request
@@ -39,11 +124,46 @@ func Delete(ctx context.Context, urlStr string, queryParam url.Values, opt ...Re
 	return req().Delete(ctx, urlStr, queryParam, opt...)
 }
 
+// DeleteJSON delete json request
+func DeleteJSON(ctx context.Context, urlStr string, body interface{}, opt ...RequestOption) (Responser, error) {
+	return req().DeleteJSON(ctx, urlStr, body, opt...)
+}
+
 // Patch patch request
 func Patch(ctx context.Context, urlStr string, queryParam url.Values, opt ...RequestOption) (Responser, error) {
 	return req().Patch(ctx, urlStr, queryParam, opt...)
 }
 
+// PatchBody patch request with a body
+func PatchBody(ctx context.Context, urlStr string, body io.Reader, opt ...RequestOption) (Responser, error) {
+	return req().PatchBody(ctx, urlStr, body, opt...)
+}
+
+// PatchJSON patch json request
+func PatchJSON(ctx context.Context, urlStr string, body interface{}, opt ...RequestOption) (Responser, error) {
+	return req().PatchJSON(ctx, urlStr, body, opt...)
+}
+
+// PatchForm patch form request
+func PatchForm(ctx context.Context, urlStr string, body url.Values, opt ...RequestOption) (Responser, error) {
+	return req().PatchForm(ctx, urlStr, body, opt...)
+}
+
+// Options options request
+func Options(ctx context.Context, urlStr string, opt ...RequestOption) (Responser, error) {
+	return req().Options(ctx, urlStr, opt...)
+}
+
+// GetCapabilities sends an options request and parses the advertised capabilities
+func GetCapabilities(ctx context.Context, urlStr string, opt ...RequestOption) (*Capabilities, error) {
+	return req().Capabilities(ctx, urlStr, opt...)
+}
+
+// Trace trace request
+func Trace(ctx context.Context, urlStr string, opt ...RequestOption) (Responser, error) {
+	return req().Trace(ctx, urlStr, opt...)
+}
+
 // Post post request
 func Post(ctx context.Context, urlStr string, body io.Reader, opt ...RequestOption) (Responser, error) {
 	return req().Post(ctx, urlStr, body, opt...)
@@ -74,6 +194,36 @@ func PutForm(ctx context.Context, urlStr string, body url.Values, opt ...Request
 	return req().PutForm(ctx, urlStr, body, opt...)
 }
 
+// PostMultipart post multipart/form-data request
+func PostMultipart(ctx context.Context, urlStr string, body *Multipart, opt ...RequestOption) (Responser, error) {
+	return req().PostMultipart(ctx, urlStr, body, opt...)
+}
+
+// PutMultipart put multipart/form-data request
+func PutMultipart(ctx context.Context, urlStr string, body *Multipart, opt ...RequestOption) (Responser, error) {
+	return req().PutMultipart(ctx, urlStr, body, opt...)
+}
+
+// Download downloads the content of urlStr into the file at path
+func Download(ctx context.Context, urlStr, path string, opt ...DownloadOption) (int64, error) {
+	return req().Download(ctx, urlStr, path, opt...)
+}
+
+// DownloadTo downloads the content of urlStr into w
+func DownloadTo(ctx context.Context, urlStr string, w io.WriterAt, opt ...DownloadOption) (int64, error) {
+	return req().DownloadTo(ctx, urlStr, w, opt...)
+}
+
+// Events consumes a text/event-stream
+func Events(ctx context.Context, urlStr string, handler func(Event) error, opt ...RequestOption) error {
+	return req().Events(ctx, urlStr, handler, opt...)
+}
+
+// DoEncoded http request with a body encoded by the codec registered for contentType
+func DoEncoded(ctx context.Context, urlStr, method, contentType string, body interface{}, opt ...RequestOption) (Responser, error) {
+	return doEncoded(ctx, req(), urlStr, method, contentType, body, opt...)
+}
+
 // Do http request
 func Do(ctx context.Context, urlStr, method string, body io.Reader, opt ...RequestOption) (Responser, error) {
 	return req().Do(ctx, urlStr, method, body, opt...)
@@ -0,0 +1,81 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDefault(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Client"))
+	}))
+	defer ts.Close()
+
+	defaultMu.Lock()
+	defaultReq = atomic.Value{}
+	defaultMu.Unlock()
+	defer SetDefault(nil)
+
+	get := func() string {
+		resp, err := Get(context.Background(), "/", nil)
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		return body
+	}
+
+	Convey("Test Default Options", t, func() {
+		So(SetOptions(SetBaseURL(ts.URL), SetBaseHeader("X-Client", "a")), ShouldBeNil)
+		So(get(), ShouldEqual, "a")
+
+		So(SetOptions(SetBaseURL(ts.URL), SetBaseHeader("X-Client", "b")), ShouldEqual, ErrDefaultInitialized)
+		So(get(), ShouldEqual, "a")
+
+		Reconfigure(SetBaseURL(ts.URL), SetBaseHeader("X-Client", "c"))
+		So(get(), ShouldEqual, "c")
+	})
+
+	Convey("Test Default Concurrent Reconfigure", t, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				Reconfigure(SetBaseURL(ts.URL), SetBaseHeader("X-Client", "d"))
+			}()
+			go func() {
+				defer wg.Done()
+				resp, err := Get(context.Background(), "/", nil)
+				if err == nil {
+					resp.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		So(get(), ShouldEqual, "d")
+	})
+
+	Convey("Test Named Clients", t, func() {
+		Register("e", New(SetBaseURL(ts.URL), SetBaseHeader("X-Client", "e")))
+		defer Register("e", nil)
+
+		r, ok := Client("e")
+		So(ok, ShouldBeTrue)
+		resp, err := r.Get(context.Background(), "/", nil)
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "e")
+
+		Register("e", nil)
+		_, ok = Client("e")
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,317 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ServiceScheme is the url scheme of logical services, the host of
+// svc://billing/v1/charges naming the billing service
+const ServiceScheme = "svc"
+
+// ErrDiscoveryClosed is returned for the svc:// requests made after their
+// Discovery was closed
+var ErrDiscoveryClosed = errors.New("req: discovery closed")
+
+// DefaultDiscoveryTTL is how long resolved endpoints are cached by default
+const DefaultDiscoveryTTL = 30 * time.Second
+
+// discoveryTimeout limits the background refreshes of a service
+const discoveryTimeout = 10 * time.Second
+
+// Resolver supplies the endpoints of logical services
+type Resolver interface {
+	Resolve(ctx context.Context, service string) ([]Endpoint, error)
+}
+
+// Watcher is implemented by resolvers learning of endpoint changes by
+// themselves, fn being called with the service whose endpoints changed
+type Watcher interface {
+	Watch(fn func(service string))
+}
+
+// DiscoveryPolicy describes how service endpoints are cached and how
+// requests are balanced over them
+type DiscoveryPolicy struct {
+	// TTL is how long resolved endpoints are used before being resolved
+	// again, DefaultDiscoveryTTL by default. Expired endpoints keep being
+	// used while they are refreshed, and when resolving them fails
+	TTL time.Duration
+	// Balancer is the policy of the balancer of each service
+	Balancer BalancerPolicy
+	// OnChange is called after the endpoints of service changed, including
+	// when they are first resolved, and with no endpoints once the resolver
+	// no longer knows the service
+	OnChange func(service string, endpoints []Endpoint)
+}
+
+// Discovery resolves requests to svc:// urls, such as
+// svc://billing/v1/charges, through a Resolver and balances them over the
+// endpoints of the service. It can be shared by several clients and must
+// be closed when the balancer policy enables active health checks
+type Discovery struct {
+	resolver Resolver
+	policy   DiscoveryPolicy
+
+	mu        sync.Mutex
+	services  map[string]*service
+	transport http.RoundTripper
+	closed    bool
+}
+
+type service struct {
+	ready      chan struct{}
+	err        error
+	endpoints  []Endpoint
+	balancer   *Balancer
+	expires    time.Time
+	refreshing bool
+	// dirty records an invalidation during a refresh, which then runs
+	// again
+	dirty bool
+}
+
+// NewDiscovery create a service discovery over r
+func NewDiscovery(r Resolver, policy DiscoveryPolicy) *Discovery {
+	if policy.TTL <= 0 {
+		policy.TTL = DefaultDiscoveryTTL
+	}
+	d := &Discovery{
+		resolver: r,
+		policy:   policy,
+		services: make(map[string]*service),
+	}
+	if w, ok := r.(Watcher); ok {
+		w.Watch(d.invalidate)
+	}
+	return d
+}
+
+// SetDiscovery resolves svc:// urls through d
+func SetDiscovery(d *Discovery) Option {
+	return func(o *options) {
+		o.discovery = d
+	}
+}
+
+// Endpoints returns the endpoints of service, resolving them if needed
+func (d *Discovery) Endpoints(ctx context.Context, name string) ([]Endpoint, error) {
+	s, err := d.service(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Endpoint(nil), s.endpoints...), nil
+}
+
+// useTransport has the balancers of the services send their health checks
+// through rt unless the balancer policy sets a transport
+func (d *Discovery) useTransport(rt http.RoundTripper) {
+	d.mu.Lock()
+	if d.transport != nil {
+		d.mu.Unlock()
+		return
+	}
+	d.transport = rt
+	var balancers []*Balancer
+	for _, s := range d.services {
+		if s.balancer != nil {
+			balancers = append(balancers, s.balancer)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, b := range balancers {
+		b.useTransport(rt)
+	}
+}
+
+// Close closes the balancers of the services, the requests made afterwards
+// failing with ErrDiscoveryClosed
+func (d *Discovery) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	services := d.services
+	d.services = make(map[string]*service)
+	d.mu.Unlock()
+
+	for _, s := range services {
+		<-s.ready
+		if s.balancer != nil {
+			s.balancer.Close()
+		}
+	}
+	return nil
+}
+
+func (d *Discovery) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		if req.URL.Scheme != ServiceScheme {
+			return next(ctx, req)
+		}
+
+		s, err := d.service(ctx, req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		// the balancer resolves the relative url against an endpoint
+		sreq := *req
+		sreq.URL = &url.URL{
+			Path:     req.URL.Path,
+			RawPath:  req.URL.RawPath,
+			RawQuery: req.URL.RawQuery,
+			Fragment: req.URL.Fragment,
+		}
+		if sreq.Host == req.URL.Host {
+			sreq.Host = ""
+		}
+		return s.balancer.middleware(next)(ctx, &sreq)
+	}
+}
+
+// service returns the service name once resolved, refreshing it in the
+// background once expired. Resolutions run on a context of their own so
+// that a caller giving up does not fail the others waiting for them
+func (d *Discovery) service(ctx context.Context, name string) (*service, error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrDiscoveryClosed
+	}
+	s, ok := d.services[name]
+	switch {
+	case !ok || (s.isReady() && s.balancer == nil):
+		// first resolution, or a retry after it failed
+		s = &service{ready: make(chan struct{}), refreshing: true}
+		d.services[name] = s
+		go func() {
+			defer close(s.ready)
+			d.resolve(name, s)
+		}()
+	case s.isReady() && !s.refreshing && !time.Now().Before(s.expires):
+		s.refreshing = true
+		go d.resolve(name, s)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if s.balancer == nil {
+		return nil, s.err
+	}
+	return s, nil
+}
+
+// resolve refreshes s within discoveryTimeout, again as long as it is
+// invalidated meanwhile
+func (d *Discovery) resolve(name string, s *service) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		d.refresh(ctx, name, s)
+		cancel()
+
+		d.mu.Lock()
+		dirty := s.dirty && d.services[name] == s
+		s.dirty = false
+		s.refreshing = dirty
+		d.mu.Unlock()
+		if !dirty {
+			return
+		}
+	}
+}
+
+// refresh resolves the endpoints of s, keeping the previous ones on failure
+// unless the resolver no longer knows the service, which is then removed
+func (d *Discovery) refresh(ctx context.Context, name string, s *service) {
+	endpoints, err := d.resolver.Resolve(ctx, name)
+	if err == nil && len(endpoints) == 0 {
+		err = ErrNoEndpoint
+	}
+
+	d.mu.Lock()
+	s.expires = time.Now().Add(d.policy.TTL)
+	if err != nil {
+		s.err = err
+		var unknown *UnknownServiceError
+		removed := errors.As(err, &unknown) && s.balancer != nil && d.services[name] == s
+		if removed {
+			delete(d.services, name)
+			s.endpoints = nil
+		}
+		d.mu.Unlock()
+
+		if removed {
+			s.balancer.Close()
+			if d.policy.OnChange != nil {
+				d.policy.OnChange(name, nil)
+			}
+		}
+		return
+	}
+	changed := !reflect.DeepEqual(s.endpoints, endpoints)
+	s.endpoints = endpoints
+	s.err = nil
+	d.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if s.balancer == nil {
+		b, err := NewBalancer(endpoints, d.policy.Balancer)
+		if err != nil {
+			s.err = err
+			return
+		}
+		d.mu.Lock()
+		s.balancer = b
+		rt := d.transport
+		d.mu.Unlock()
+		if rt != nil {
+			b.useTransport(rt)
+		}
+	} else if err := s.balancer.SetEndpoints(endpoints); err != nil {
+		return
+	}
+	if d.policy.OnChange != nil {
+		d.policy.OnChange(name, append([]Endpoint(nil), endpoints...))
+	}
+}
+
+// invalidate makes the endpoints of service expire, refreshing them
+func (d *Discovery) invalidate(name string) {
+	d.mu.Lock()
+	s, ok := d.services[name]
+	refreshing := ok && s.refreshing
+	if refreshing {
+		// the running refresh may have resolved the previous endpoints
+		s.dirty = true
+	} else if ok {
+		s.expires = time.Time{}
+	}
+	d.mu.Unlock()
+
+	if ok && !refreshing {
+		d.service(context.Background(), name)
+	}
+}
+
+func (s *service) isReady() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,430 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// countingResolver counts resolutions and can be switched to failing
+type countingResolver struct {
+	Resolver
+	calls int32
+	fail  int32
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	atomic.AddInt32(&r.calls, 1)
+	if atomic.LoadInt32(&r.fail) != 0 {
+		return nil, errors.New("resolver down")
+	}
+	return r.Resolver.Resolve(ctx, service)
+}
+
+// blockingResolver resolves once release is closed
+type blockingResolver struct {
+	Resolver
+	release chan struct{}
+}
+
+func (r blockingResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return r.Resolver.Resolve(ctx, service)
+}
+
+// watchedResolver notifies its watcher of the changes made through set,
+// and holds the next resolution after reading the endpoints once hold is
+// called, until the returned func is
+type watchedResolver struct {
+	mu        sync.Mutex
+	endpoints []Endpoint
+	held      chan struct{}
+	release   chan struct{}
+	fn        func(service string)
+}
+
+func (r *watchedResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	r.mu.Lock()
+	endpoints := r.endpoints
+	held, release := r.held, r.release
+	r.held, r.release = nil, nil
+	r.mu.Unlock()
+
+	if held != nil {
+		close(held)
+		<-release
+	}
+	return endpoints, nil
+}
+
+func (r *watchedResolver) Watch(fn func(service string)) {
+	r.fn = fn
+}
+
+func (r *watchedResolver) set(endpoints []Endpoint) {
+	r.mu.Lock()
+	r.endpoints = endpoints
+	r.mu.Unlock()
+	r.fn("billing")
+}
+
+func (r *watchedResolver) hold() (held chan struct{}, release func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.held, r.release = make(chan struct{}), make(chan struct{})
+	held, ch := r.held, r.release
+	return held, func() { close(ch) }
+}
+
+func TestDiscovery(t *testing.T) {
+	a, b := newBalancedServer("a"), newBalancedServer("b")
+	defer a.Close()
+	defer b.Close()
+
+	ctx := context.Background()
+
+	Convey("Static resolver", t, func() {
+		d := NewDiscovery(StaticResolver{
+			"billing": {{URL: a.URL + "/api"}, {URL: b.URL + "/api"}},
+		}, DiscoveryPolicy{})
+		defer d.Close()
+		r := New(SetDiscovery(d), SetBaseURL("http://unused.invalid"))
+
+		var got []string
+		for i := 0; i < 2; i++ {
+			resp, err := r.Get(ctx, "svc://billing/v1/charges", map[string][]string{"id": {"7"}})
+			So(err, ShouldBeNil)
+			s, _ := resp.String()
+			got = append(got, s)
+		}
+		So(got, ShouldResemble, []string{"a /api/v1/charges?id=7 ", "b /api/v1/charges?id=7 "})
+
+		_, err := r.Get(ctx, "svc://unknown/", nil)
+		var unknown *UnknownServiceError
+		So(errors.As(err, &unknown), ShouldBeTrue)
+		So(unknown.Service, ShouldEqual, "unknown")
+	})
+
+	Convey("Endpoints are cached and refreshed", t, func() {
+		static := StaticResolver{"billing": {{URL: a.URL}}}
+		resolver := &countingResolver{Resolver: static}
+
+		var (
+			mu      sync.Mutex
+			changes [][]Endpoint
+		)
+		d := NewDiscovery(resolver, DiscoveryPolicy{
+			TTL: 50 * time.Millisecond,
+			OnChange: func(service string, endpoints []Endpoint) {
+				mu.Lock()
+				defer mu.Unlock()
+				changes = append(changes, endpoints)
+			},
+		})
+		defer d.Close()
+		r := New(SetDiscovery(d))
+
+		for i := 0; i < 3; i++ {
+			_, err := r.Get(ctx, "svc://billing/", nil)
+			So(err, ShouldBeNil)
+		}
+		So(atomic.LoadInt32(&resolver.calls), ShouldEqual, 1)
+
+		// expired endpoints are used while refreshed
+		static["billing"] = []Endpoint{{URL: b.URL}}
+		time.Sleep(60 * time.Millisecond)
+		resp, err := r.Get(ctx, "svc://billing/", nil)
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldStartWith, "a ")
+
+		var endpoints []Endpoint
+		for i := 0; i < 100; i++ {
+			if endpoints, _ = d.Endpoints(ctx, "billing"); endpoints[0].URL == b.URL {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		So(endpoints, ShouldResemble, []Endpoint{{URL: b.URL}})
+		resp, err = r.Get(ctx, "svc://billing/", nil)
+		So(err, ShouldBeNil)
+		s, _ = resp.String()
+		So(s, ShouldStartWith, "b ")
+
+		mu.Lock()
+		So(changes, ShouldResemble, [][]Endpoint{{{URL: a.URL}}, {{URL: b.URL}}})
+		mu.Unlock()
+
+		Convey("and kept when resolving fails", func() {
+			atomic.StoreInt32(&resolver.fail, 1)
+			time.Sleep(60 * time.Millisecond)
+			for i := 0; i < 3; i++ {
+				_, err := r.Get(ctx, "svc://billing/", nil)
+				So(err, ShouldBeNil)
+				time.Sleep(20 * time.Millisecond)
+			}
+			So(atomic.LoadInt32(&resolver.calls), ShouldBeGreaterThan, 2)
+		})
+	})
+
+	Convey("Resolution outlives a canceled caller", t, func() {
+		release := make(chan struct{})
+		d := NewDiscovery(blockingResolver{StaticResolver{"billing": {{URL: a.URL}}}, release}, DiscoveryPolicy{})
+		r := New(SetDiscovery(d))
+
+		cctx, cancel := context.WithCancel(ctx)
+		canceled := make(chan error, 1)
+		go func() {
+			_, err := r.Get(cctx, "svc://billing/", nil)
+			canceled <- err
+		}()
+		waiting := make(chan error, 1)
+		go func() {
+			resp, err := r.Get(ctx, "svc://billing/", nil)
+			if err == nil {
+				resp.Close()
+			}
+			waiting <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		So(errors.Is(<-canceled, context.Canceled), ShouldBeTrue)
+		close(release)
+		So(<-waiting, ShouldBeNil)
+
+		Convey("and requests fail once closed", func() {
+			So(d.Close(), ShouldBeNil)
+			_, err := r.Get(ctx, "svc://billing/", nil)
+			So(err, ShouldEqual, ErrDiscoveryClosed)
+			_, err = d.Endpoints(ctx, "other")
+			So(err, ShouldEqual, ErrDiscoveryClosed)
+		})
+	})
+
+	Convey("Changes during a refresh", t, func() {
+		resolver := &watchedResolver{endpoints: []Endpoint{{URL: a.URL}}}
+		changed := make(chan []Endpoint, 4)
+		d := NewDiscovery(resolver, DiscoveryPolicy{
+			TTL: time.Hour,
+			OnChange: func(service string, endpoints []Endpoint) {
+				changed <- endpoints
+			},
+		})
+		defer d.Close()
+
+		endpoints, err := d.Endpoints(ctx, "billing")
+		So(err, ShouldBeNil)
+		So(endpoints, ShouldResemble, []Endpoint{{URL: a.URL}})
+		<-changed
+
+		// the running refresh reads the endpoints before they change
+		held, release := resolver.hold()
+		resolver.set([]Endpoint{{URL: a.URL}})
+		<-held
+		resolver.set([]Endpoint{{URL: b.URL}})
+		release()
+
+		select {
+		case endpoints := <-changed:
+			So(endpoints, ShouldResemble, []Endpoint{{URL: b.URL}})
+		case <-time.After(2 * time.Second):
+			So("no change notification", ShouldBeEmpty)
+		}
+	})
+
+	Convey("Removed services", t, func() {
+		path := filepath.Join(t.TempDir(), "services.json")
+		So(ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"billing": [{"url": %q}], "users": [{"url": %q}]}`, a.URL, b.URL)), 0o644), ShouldBeNil)
+		fr, err := NewFileResolver(path, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+		defer fr.Close()
+
+		changed := make(chan []Endpoint, 4)
+		d := NewDiscovery(fr, DiscoveryPolicy{
+			TTL: time.Hour,
+			OnChange: func(service string, endpoints []Endpoint) {
+				if service == "billing" {
+					changed <- endpoints
+				}
+			},
+		})
+		defer d.Close()
+		r := New(SetDiscovery(d))
+
+		resp, err := r.Get(ctx, "svc://billing/", nil)
+		So(err, ShouldBeNil)
+		resp.Close()
+		So(<-changed, ShouldResemble, []Endpoint{{URL: a.URL}})
+
+		So(ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"users": [{"url": %q}]}`, b.URL)), 0o644), ShouldBeNil)
+		select {
+		case endpoints := <-changed:
+			So(endpoints, ShouldBeNil)
+		case <-time.After(2 * time.Second):
+			So("no change notification", ShouldBeEmpty)
+		}
+
+		_, err = r.Get(ctx, "svc://billing/", nil)
+		var unknown *UnknownServiceError
+		So(errors.As(err, &unknown), ShouldBeTrue)
+	})
+
+	Convey("File resolver", t, func() {
+		dir := t.TempDir()
+
+		for _, tc := range []struct{ name, first, second string }{
+			{"services.json",
+				fmt.Sprintf(`{"billing": [{"url": %q}]}`, a.URL),
+				fmt.Sprintf(`{"billing": [{"url": %q, "weight": 2}], "users": [{"url": %q}]}`, b.URL, a.URL)},
+			{"services.yaml",
+				fmt.Sprintf("billing:\n  - url: %s\n", a.URL),
+				fmt.Sprintf("billing:\n  - url: %s\n    weight: 2\nusers:\n  - url: %s\n", b.URL, a.URL)},
+		} {
+			path := filepath.Join(dir, tc.name)
+			So(ioutil.WriteFile(path, []byte(tc.first), 0o644), ShouldBeNil)
+
+			fr, err := NewFileResolver(path, 10*time.Millisecond)
+			So(err, ShouldBeNil)
+
+			changed := make(chan []Endpoint, 4)
+			d := NewDiscovery(fr, DiscoveryPolicy{
+				TTL: time.Hour,
+				OnChange: func(service string, endpoints []Endpoint) {
+					if service == "billing" {
+						changed <- endpoints
+					}
+				},
+			})
+			r := New(SetDiscovery(d))
+
+			resp, err := r.Get(ctx, "svc://billing/x", nil)
+			So(err, ShouldBeNil)
+			s, _ := resp.String()
+			So(s, ShouldEqual, "a /x ")
+			So(<-changed, ShouldResemble, []Endpoint{{URL: a.URL}})
+
+			So(ioutil.WriteFile(path, []byte(tc.second), 0o644), ShouldBeNil)
+			select {
+			case endpoints := <-changed:
+				So(endpoints, ShouldResemble, []Endpoint{{URL: b.URL, Weight: 2}})
+			case <-time.After(2 * time.Second):
+				So("no change notification", ShouldBeEmpty)
+			}
+			resp, err = r.Get(ctx, "svc://billing/x", nil)
+			So(err, ShouldBeNil)
+			s, _ = resp.String()
+			So(s, ShouldEqual, "b /x ")
+
+			// a broken file keeps the previous endpoints
+			So(ioutil.WriteFile(path, []byte("{not valid"), 0o644), ShouldBeNil)
+			time.Sleep(30 * time.Millisecond)
+			endpoints, err := fr.Resolve(ctx, "users")
+			So(err, ShouldBeNil)
+			So(endpoints, ShouldResemble, []Endpoint{{URL: a.URL}})
+
+			d.Close()
+			fr.Close()
+		}
+
+		_, err := NewFileResolver(filepath.Join(dir, "missing.json"), 0)
+		So(os.IsNotExist(errors.Unwrap(err)) || os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("DNS SRV resolver", t, func() {
+		_, port, _ := net.SplitHostPort(a.Listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+		dns := newSRVServer(t, []dnsmessage.SRVResource{
+			{Priority: 10, Weight: 3, Port: uint16(p), Target: dnsmessage.MustNewName("localhost.")},
+			{Priority: 20, Weight: 1, Port: 9, Target: dnsmessage.MustNewName("backup.example.")},
+		})
+
+		resolver := &SRVResolver{
+			Service: "http",
+			Proto:   "tcp",
+			Domain:  "svc.local",
+			Resolver: &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "udp", dns)
+				},
+			},
+		}
+		endpoints, err := resolver.Resolve(ctx, "billing")
+		So(err, ShouldBeNil)
+		So(endpoints, ShouldResemble, []Endpoint{{URL: "http://localhost:" + port, Weight: 3}})
+
+		d := NewDiscovery(resolver, DiscoveryPolicy{})
+		defer d.Close()
+		resp, err := New(SetDiscovery(d)).Get(ctx, "svc://billing/srv", nil)
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldEqual, "a /srv ")
+	})
+}
+
+// newSRVServer serves the SRV records for _http._tcp.billing.svc.local over
+// UDP, returning its address
+func newSRVServer(t *testing.T, records []dnsmessage.SRVResource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			rcode := dnsmessage.RCodeSuccess
+			if q.Type != dnsmessage.TypeSRV || q.Name.String() != "_http._tcp.billing.svc.local." {
+				rcode = dnsmessage.RCodeNameError
+			}
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RCode: rcode})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if rcode == dnsmessage.RCodeSuccess {
+				for _, srv := range records {
+					b.SRVResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, srv)
+				}
+			}
+			msg, err := b.Finish()
+			if err == nil {
+				conn.WriteTo(msg, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
@@ -0,0 +1,378 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default download settings
+const (
+	DefaultDownloadConcurrency = 4
+	DefaultDownloadChunkSize   = 8 << 20
+)
+
+// ErrChecksumMismatch is returned when a downloaded content does not
+// match the expected checksum
+var ErrChecksumMismatch = errors.New("req: download checksum mismatch")
+
+// downloadStateSuffix is appended to the destination path to name the
+// sidecar file recording the completed chunks
+const downloadStateSuffix = ".download"
+
+type downloadOptions struct {
+	concurrency int
+	chunkSize   int64
+	hash        func() hash.Hash
+	checksum    []byte
+	requestOpts []RequestOption
+	err         error
+}
+
+// DownloadOption download parameter options
+type DownloadOption func(*downloadOptions)
+
+// SetDownloadConcurrency sets the number of chunks fetched in parallel
+func SetDownloadConcurrency(n int) DownloadOption {
+	return func(o *downloadOptions) {
+		o.concurrency = n
+	}
+}
+
+// SetDownloadChunkSize sets the size of the ranges the content is split into
+func SetDownloadChunkSize(size int64) DownloadOption {
+	return func(o *downloadOptions) {
+		o.chunkSize = size
+	}
+}
+
+// SetDownloadChecksum verifies the downloaded content against the hex
+// encoded sum computed by h, e.g. sha256.New. The download fails before
+// any request when sum is not valid hex
+func SetDownloadChecksum(h func() hash.Hash, sum string) DownloadOption {
+	return func(o *downloadOptions) {
+		o.hash = h
+		checksum, err := hex.DecodeString(sum)
+		if err != nil {
+			o.err = fmt.Errorf("req: invalid download checksum %q: %w", sum, err)
+			return
+		}
+		o.checksum = checksum
+	}
+}
+
+// SetDownloadRequest sets the options of every request made by the download
+func SetDownloadRequest(opts ...RequestOption) DownloadOption {
+	return func(o *downloadOptions) {
+		o.requestOpts = append(o.requestOpts, opts...)
+	}
+}
+
+// downloadState is persisted in the sidecar file to resume a download
+type downloadState struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	Validator string `json:"validator"`
+	ChunkSize int64  `json:"chunk_size"`
+	Done      []bool `json:"done"`
+}
+
+type downloader struct {
+	r       *request
+	urlStr  string
+	w       io.WriterAt
+	opts    downloadOptions
+	state   *downloadState
+	persist func(*downloadState) error
+}
+
+func (r *request) Download(ctx context.Context, urlStr, path string, opts ...DownloadOption) (int64, error) {
+	d, err := r.downloader(urlStr, nil, opts)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	d.w = f
+
+	statePath := path + downloadStateSuffix
+	if buf, err := ioutil.ReadFile(statePath); err == nil {
+		state := new(downloadState)
+		if json.Unmarshal(buf, state) == nil {
+			d.state = state
+		}
+	}
+	d.persist = func(state *downloadState) error {
+		buf, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		tmp := statePath + ".tmp"
+		if err := ioutil.WriteFile(tmp, buf, 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, statePath)
+	}
+
+	n, err := d.run(ctx)
+	if err != nil {
+		return n, err
+	}
+	if err := f.Truncate(n); err != nil {
+		return n, err
+	}
+	os.Remove(statePath)
+	return n, nil
+}
+
+func (r *request) DownloadTo(ctx context.Context, urlStr string, w io.WriterAt, opts ...DownloadOption) (int64, error) {
+	d, err := r.downloader(urlStr, w, opts)
+	if err != nil {
+		return 0, err
+	}
+	return d.run(ctx)
+}
+
+func (r *request) downloader(urlStr string, w io.WriterAt, opts []DownloadOption) (*downloader, error) {
+	o := downloadOptions{
+		concurrency: DefaultDownloadConcurrency,
+		chunkSize:   DefaultDownloadChunkSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = 1
+	}
+	if o.chunkSize <= 0 {
+		o.chunkSize = DefaultDownloadChunkSize
+	}
+	return &downloader{r: r, urlStr: urlStr, w: w, opts: o}, nil
+}
+
+// run probes the resource with HEAD and downloads it in parallel ranges,
+// or as a single stream when the server does not support ranges
+func (d *downloader) run(ctx context.Context) (int64, error) {
+	resp, err := d.r.Head(ctx, d.urlStr, nil, d.opts.requestOpts...)
+	if err != nil {
+		return 0, err
+	}
+	resp.Close()
+	res := resp.Response()
+
+	size := res.ContentLength
+	if res.StatusCode != http.StatusOK || size <= 0 || res.Header.Get(HeaderAcceptRanges) != "bytes" {
+		return d.stream(ctx)
+	}
+
+	validator := res.Header.Get(HeaderETag)
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = res.Header.Get(HeaderLastModified)
+	}
+
+	chunks := int((size + d.opts.chunkSize - 1) / d.opts.chunkSize)
+	s := d.state
+	if s == nil || s.URL != d.urlStr || s.Size != size || s.Validator != validator || s.ChunkSize != d.opts.chunkSize || len(s.Done) != chunks {
+		s = &downloadState{
+			URL:       d.urlStr,
+			Size:      size,
+			Validator: validator,
+			ChunkSize: d.opts.chunkSize,
+			Done:      make([]bool, chunks),
+		}
+	}
+	d.state = s
+
+	if err := d.chunks(ctx); err != nil {
+		return 0, err
+	}
+	if err := d.verify(nil, size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (d *downloader) chunks(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	todo := make(chan int)
+	go func() {
+		defer close(todo)
+		for i, done := range d.state.Done {
+			if done {
+				continue
+			}
+			select {
+			case todo <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if first == nil {
+			first = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	for i := 0; i < d.opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range todo {
+				if err := d.chunk(ctx, idx); err != nil {
+					fail(err)
+					return
+				}
+
+				mu.Lock()
+				d.state.Done[idx] = true
+				var err error
+				if d.persist != nil {
+					err = d.persist(d.state)
+				}
+				mu.Unlock()
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if first != nil {
+		return first
+	}
+	return ctx.Err()
+}
+
+func (d *downloader) chunk(ctx context.Context, idx int) error {
+	start := int64(idx) * d.state.ChunkSize
+	end := start + d.state.ChunkSize - 1
+	if end >= d.state.Size {
+		end = d.state.Size - 1
+	}
+
+	opts := []RequestOption{SetHeader(HeaderRange, fmt.Sprintf("bytes=%d-%d", start, end))}
+	if d.state.Validator != "" {
+		opts = append(opts, SetHeader(HeaderIfRange, d.state.Validator))
+	}
+	resp, err := d.r.Get(ctx, d.urlStr, nil, append(opts, d.opts.requestOpts...)...)
+	if err != nil {
+		return err
+	}
+	res := resp.Response()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		if err := checkStatus(res); err != nil {
+			return err
+		}
+		return fmt.Errorf("req: download of %s changed while resuming", d.urlStr)
+	}
+	if got := res.Header.Get(HeaderContentRange); !strings.HasPrefix(got, "bytes "+strconv.FormatInt(start, 10)+"-") {
+		return fmt.Errorf("req: unexpected Content-Range %q", got)
+	}
+
+	n, err := io.Copy(&offsetWriter{w: d.w, off: start}, res.Body)
+	if err != nil {
+		return err
+	}
+	if n != end-start+1 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// stream downloads the content in a single request
+func (d *downloader) stream(ctx context.Context) (int64, error) {
+	resp, err := d.r.Get(ctx, d.urlStr, nil, d.opts.requestOpts...)
+	if err != nil {
+		return 0, err
+	}
+	res := resp.Response()
+	defer res.Body.Close()
+
+	if err := checkStatus(res); err != nil {
+		return 0, err
+	}
+
+	var h hash.Hash
+	var w io.Writer = &offsetWriter{w: d.w}
+	if d.opts.hash != nil {
+		h = d.opts.hash()
+		w = io.MultiWriter(w, h)
+	}
+
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return n, err
+	}
+	if err := d.verify(h, n); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// verify checks the checksum, hashing the written content back when h is
+// nil, which requires the destination to be an io.ReaderAt
+func (d *downloader) verify(h hash.Hash, size int64) error {
+	if d.opts.hash == nil {
+		return nil
+	}
+
+	if h == nil {
+		ra, ok := d.w.(io.ReaderAt)
+		if !ok {
+			return errors.New("req: checksum requires the destination to implement io.ReaderAt")
+		}
+		h = d.opts.hash()
+		if _, err := io.Copy(h, io.NewSectionReader(ra, 0, size)); err != nil {
+			return err
+		}
+	}
+
+	if sum := h.Sum(nil); !bytes.Equal(sum, d.opts.checksum) {
+		return fmt.Errorf("%w: got %x, want %x", ErrChecksumMismatch, sum, d.opts.checksum)
+	}
+	return nil
+}
+
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
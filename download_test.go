@@ -0,0 +1,133 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	var ranges, hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/plain" {
+			w.Write(content)
+			return
+		}
+		if r.Header.Get(HeaderRange) != "" {
+			atomic.AddInt32(&ranges, 1)
+		}
+		w.Header().Set(HeaderETag, `"v1"`)
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+
+	Convey("Test Download Chunked", t, func() {
+		atomic.StoreInt32(&ranges, 0)
+		r := New(SetBaseURL(ts.URL))
+		path := filepath.Join(dir, "chunked.bin")
+
+		n, err := r.Download(context.Background(), "/data", path,
+			SetDownloadChunkSize(10000),
+			SetDownloadConcurrency(3),
+			SetDownloadChecksum(sha256.New, checksum))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, len(content))
+		So(atomic.LoadInt32(&ranges), ShouldEqual, 7)
+
+		got, err := os.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(got, content), ShouldBeTrue)
+
+		_, err = os.Stat(path + downloadStateSuffix)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test Download Resume", t, func() {
+		atomic.StoreInt32(&ranges, 0)
+		r := New(SetBaseURL(ts.URL))
+		path := filepath.Join(dir, "resume.bin")
+
+		partial := make([]byte, len(content))
+		copy(partial, content[:30000])
+		So(os.WriteFile(path, partial, 0o644), ShouldBeNil)
+
+		state, _ := json.Marshal(&downloadState{
+			URL:       "/data",
+			Size:      int64(len(content)),
+			Validator: `"v1"`,
+			ChunkSize: 10000,
+			Done:      []bool{true, true, true, false, false, false, false},
+		})
+		So(os.WriteFile(path+downloadStateSuffix, state, 0o644), ShouldBeNil)
+
+		_, err := r.Download(context.Background(), "/data", path,
+			SetDownloadChunkSize(10000),
+			SetDownloadChecksum(sha256.New, checksum))
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(&ranges), ShouldEqual, 4)
+
+		got, err := os.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(got, content), ShouldBeTrue)
+	})
+
+	Convey("Test Download Without Ranges", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		f, err := os.Create(filepath.Join(dir, "plain.bin"))
+		So(err, ShouldBeNil)
+		defer f.Close()
+
+		n, err := r.DownloadTo(context.Background(), "/plain", f, SetDownloadChecksum(sha256.New, checksum))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, len(content))
+	})
+
+	Convey("Test Download Checksum Mismatch", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		_, err := r.Download(context.Background(), "/data", filepath.Join(dir, "bad.bin"),
+			SetDownloadChunkSize(10000),
+			SetDownloadChecksum(sha256.New, "00"))
+		So(errors.Is(err, ErrChecksumMismatch), ShouldBeTrue)
+	})
+	Convey("Test Download Invalid Checksum", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL))
+
+		path := filepath.Join(dir, "invalid.bin")
+		_, err := r.Download(context.Background(), "/data", path,
+			SetDownloadChecksum(sha256.New, "not hex"))
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrChecksumMismatch), ShouldBeFalse)
+		_, err = os.Stat(path)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		f, err := os.Create(filepath.Join(dir, "invalid-to.bin"))
+		So(err, ShouldBeNil)
+		defer f.Close()
+		_, err = r.DownloadTo(context.Background(), "/data", f,
+			SetDownloadChecksum(sha256.New, "abc"))
+		So(err, ShouldNotBeNil)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 0)
+	})
+}
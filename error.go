@@ -0,0 +1,123 @@
+package req
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// statusErrorBodyLimit bounds the body snapshot kept by a StatusError
+const statusErrorBodyLimit = 4 << 10
+
+// StatusError is returned for non-2xx responses when status checking is
+// enabled through SetBaseStatusError or SetStatusError
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body holds at most the first 4KB of the response body
+	Body []byte
+	// Problem is set when the body is an RFC 7807 problem document
+	Problem *Problem
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("req: %s %s: %s", e.Method, e.URL, e.Status)
+	if p := e.Problem; p != nil {
+		if p.Title != "" {
+			msg += ": " + p.Title
+		}
+		if p.Detail != "" {
+			msg += ": " + p.Detail
+		}
+	}
+	return msg
+}
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Extensions holds the members not defined by RFC 7807
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	type problem Problem
+	if err := json.Unmarshal(data, (*problem)(p)); err != nil {
+		return err
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, k)
+	}
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// SetBaseStatusError makes every request fail with a *StatusError
+// when the response status is not 2xx
+func SetBaseStatusError(enable bool) Option {
+	return func(o *options) {
+		o.statusError = enable
+	}
+}
+
+// SetStatusError makes the request fail with a *StatusError when the
+// response status is not 2xx, overriding SetBaseStatusError
+func SetStatusError(enable bool) RequestOption {
+	return func(o *requestOptions) {
+		o.statusError = &enable
+	}
+}
+
+// checkStatus converts a non-2xx response into a *StatusError, closing
+// its body
+func checkStatus(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	e := &StatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Header:     res.Header,
+	}
+	if e.Status == "" {
+		e.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	if req := res.Request; req != nil {
+		e.Method = req.Method
+		if req.URL != nil {
+			e.URL = req.URL.String()
+		}
+	}
+
+	if res.Body != nil {
+		e.Body, _ = ioutil.ReadAll(io.LimitReader(res.Body, statusErrorBodyLimit))
+		drainBody(res.Body)
+	}
+
+	if mt, _, _ := mime.ParseMediaType(res.Header.Get(HeaderContentType)); mt == MIMEApplicationProblemJSON {
+		p := new(Problem)
+		if json.Unmarshal(e.Body, p) == nil {
+			e.Problem = p
+		}
+	}
+	return e
+}
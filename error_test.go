@@ -0,0 +1,75 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/problem":
+			w.Header().Set(HeaderContentType, MIMEApplicationProblemJSON)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your current balance is 30, but that costs 50.","balance":30}`)
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "boom")
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer ts.Close()
+
+	Convey("Test Status Error Disabled", t, func() {
+		r := New()
+
+		resp, err := r.Get(context.Background(), ts.URL+"/fail", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusInternalServerError)
+		resp.Close()
+	})
+
+	Convey("Test Status Error", t, func() {
+		r := New(SetBaseStatusError(true))
+
+		resp, err := r.Get(context.Background(), ts.URL+"/ok", nil)
+		So(err, ShouldBeNil)
+		resp.Close()
+
+		resp, err = r.Get(context.Background(), ts.URL+"/fail", nil)
+		So(resp, ShouldBeNil)
+
+		var se *StatusError
+		So(errors.As(err, &se), ShouldBeTrue)
+		So(se.StatusCode, ShouldEqual, http.StatusInternalServerError)
+		So(se.Method, ShouldEqual, http.MethodGet)
+		So(se.URL, ShouldEqual, ts.URL+"/fail")
+		So(string(se.Body), ShouldEqual, "boom")
+		So(se.Problem, ShouldBeNil)
+
+		resp, err = r.Get(context.Background(), ts.URL+"/fail", nil, SetStatusError(false))
+		So(err, ShouldBeNil)
+		resp.Close()
+	})
+
+	Convey("Test Status Error Problem", t, func() {
+		r := New()
+
+		_, err := r.Get(context.Background(), ts.URL+"/problem", nil, SetStatusError(true))
+
+		var se *StatusError
+		So(errors.As(err, &se), ShouldBeTrue)
+		So(se.Problem, ShouldNotBeNil)
+		So(se.Problem.Status, ShouldEqual, http.StatusForbidden)
+		So(se.Problem.Title, ShouldEqual, "You do not have enough credit.")
+		So(se.Problem.Extensions["balance"], ShouldEqual, 30)
+		So(err.Error(), ShouldContainSubstring, "Your current balance is 30")
+	})
+}
@@ -0,0 +1,226 @@
+package req
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEventRetry is the reconnection delay used until the server
+// provides one with a retry field
+const DefaultEventRetry = 3 * time.Second
+
+// eventMaxLine bounds the length of a single line of an event stream
+const eventMaxLine = 1 << 20
+
+// Event is a server-sent event
+type Event struct {
+	// ID is the last event ID of the stream when the event was dispatched
+	ID string
+	// Event is the event type, "message" when not specified
+	Event string
+	Data  string
+}
+
+// Events consumes the text/event-stream at urlStr and calls handler for
+// every event until ctx is done, the server answers 204 No Content, or
+// handler returns an error. The connection is re-established after the
+// stream ends or breaks, waiting for the retry interval sent by the
+// server and resuming with the Last-Event-ID header. A non-200 response
+// stops the stream with a *StatusError
+func (r *request) Events(ctx context.Context, urlStr string, handler func(Event) error, opts ...RequestOption) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	es := &eventStream{retry: DefaultEventRetry}
+	for {
+		err := r.eventsOnce(ctx, urlStr, es, handler, opts)
+		if err != errReconnect {
+			return err
+		}
+
+		t := time.NewTimer(es.retry)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// errReconnect tells Events to establish the connection again
+var errReconnect = errors.New("req: event stream reconnect")
+
+func (r *request) eventsOnce(ctx context.Context, urlStr string, es *eventStream, handler func(Event) error, opts []RequestOption) error {
+	ro := []RequestOption{
+		SetHeader(HeaderAccept, MIMETextEventStream),
+		SetHeader(HeaderCacheControl, "no-cache"),
+	}
+	if es.lastID != "" {
+		ro = append(ro, SetHeader(HeaderLastEventID, es.lastID))
+	}
+
+	resp, err := r.Get(ctx, urlStr, nil, append(ro, opts...)...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isTransportError(err) {
+			return errReconnect
+		}
+		return err
+	}
+	res := resp.Response()
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return checkStatus(res)
+	}
+	if mt := mediaType(res.Header.Get(HeaderContentType)); mt != MIMETextEventStream {
+		return fmt.Errorf("req: unexpected event stream content type %q", mt)
+	}
+
+	err = es.read(res.Body, handler)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// eventStream holds the parser state kept across reconnections
+type eventStream struct {
+	lastID string
+	retry  time.Duration
+}
+
+// read parses the stream as described by the WHATWG HTML specification
+// (9.2.6), returning errReconnect when the stream ends or breaks
+func (es *eventStream) read(body io.Reader, handler func(Event) error) error {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 4096), eventMaxLine)
+	sc.Split(scanEventLines)
+
+	var (
+		data      strings.Builder
+		eventType string
+		first     = true
+	)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if first {
+			line = bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+			first = false
+		}
+
+		if len(line) == 0 {
+			if data.Len() > 0 {
+				ev := Event{
+					ID:    es.lastID,
+					Event: eventType,
+					Data:  strings.TrimSuffix(data.String(), "\n"),
+				}
+				if ev.Event == "" {
+					ev.Event = "message"
+				}
+				if err := handler(ev); err != nil {
+					return err
+				}
+			}
+			data.Reset()
+			eventType = ""
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+
+		switch string(field) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			data.Write(value)
+			data.WriteByte('\n')
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				es.lastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil && isDigits(value) {
+				es.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	// a broken connection is established again, unlike a stream that
+	// cannot be parsed such as one with a line over eventMaxLine
+	if err := sc.Err(); err != nil && !isTransportError(err) {
+		return err
+	}
+	return errReconnect
+}
+
+// isTransportError reports whether err comes from the connection rather
+// than from the request or the response
+func isTransportError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// scanEventLines splits lines ended by CRLF, LF or CR
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		// an unterminated last line never completes an event
+		return len(data), nil, nil
+	}
+	return 0, nil, nil
+}
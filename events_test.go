@@ -0,0 +1,109 @@
+package req
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEvents(t *testing.T) {
+	var conns int32
+	var lastIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&conns, 1)
+		lastIDs = append(lastIDs, r.Header.Get(HeaderLastEventID))
+
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/long":
+			w.Header().Set(HeaderContentType, MIMETextEventStream)
+			fmt.Fprintf(w, "data: %s\n\n", strings.Repeat("x", eventMaxLine))
+			return
+		}
+		if r.URL.Path == "/json" {
+			w.Header().Set(HeaderContentType, MIMEApplicationJSON)
+			return
+		}
+
+		w.Header().Set(HeaderContentType, MIMETextEventStream)
+		switch n {
+		case 1:
+			fmt.Fprint(w, "\xEF\xBB\xBFretry: 10\n\n")
+			fmt.Fprint(w, ": comment\n")
+			fmt.Fprint(w, "id: 1\ndata: first\ndata:  line\n\n")
+			fmt.Fprint(w, "event: update\r\ndata\r\nid: 2\r\n\r\n")
+			fmt.Fprint(w, "data: partial")
+		case 2:
+			fmt.Fprint(w, "data: resumed\r\r")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	Convey("Test Events", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		var events []Event
+		start := time.Now()
+		err := r.Events(context.Background(), "/events", func(ev Event) error {
+			events = append(events, ev)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(events, ShouldResemble, []Event{
+			{ID: "1", Event: "message", Data: "first\n line"},
+			{ID: "2", Event: "update", Data: ""},
+			{ID: "2", Event: "message", Data: "resumed"},
+		})
+		So(lastIDs, ShouldResemble, []string{"", "2", "2"})
+	})
+
+	Convey("Test Events Handler Error", t, func() {
+		atomic.StoreInt32(&conns, 0)
+		r := New(SetBaseURL(ts.URL))
+
+		errStop := errors.New("stop")
+		err := r.Events(context.Background(), "/events", func(ev Event) error {
+			return errStop
+		})
+		So(err, ShouldEqual, errStop)
+	})
+
+	Convey("Test Events Content Type", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		err := r.Events(context.Background(), "/json", func(ev Event) error { return nil })
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Test Events Stops On Request Errors", t, func() {
+		atomic.StoreInt32(&conns, 0)
+		noop := func(ev Event) error { return nil }
+
+		err := New(SetBaseURL(ts.URL), SetBaseStatusError(true)).Events(context.Background(), "/missing", noop)
+		var statusErr *StatusError
+		So(errors.As(err, &statusErr), ShouldBeTrue)
+		So(statusErr.StatusCode, ShouldEqual, http.StatusNotFound)
+		So(atomic.LoadInt32(&conns), ShouldEqual, 1)
+
+		err = New().Events(context.Background(), "http://[::1", noop)
+		So(err, ShouldNotBeNil)
+		So(atomic.LoadInt32(&conns), ShouldEqual, 1)
+
+		err = New(SetBaseURL(ts.URL)).Events(context.Background(), "/long", noop)
+		So(errors.Is(err, bufio.ErrTooLong), ShouldBeTrue)
+		So(atomic.LoadInt32(&conns), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,256 @@
+package req
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default hedging settings
+const (
+	DefaultHedgeDelay      = 100 * time.Millisecond
+	DefaultHedgeMinSamples = 20
+)
+
+// maxHedges is the number of extra copies a request may be hedged with
+const maxHedges = 2
+
+// hedgeSamples is the number of latencies a client keeps to compute the
+// hedging percentile
+const hedgeSamples = 256
+
+// hedgeKey carries the per-request hedging policy in the context
+type hedgeKey struct{}
+
+// HedgePolicy describes how a request is hedged: when it has not succeeded
+// after a delay, an extra copy is sent, the first successful response
+// winning and the other copies being canceled. A copy failing with an
+// error or a 5xx response triggers the next one at once
+type HedgePolicy struct {
+	// Delay is how long to wait before sending each extra copy, also used
+	// while fewer than MinSamples latencies are known when Percentile is
+	// set. It defaults to DefaultHedgeDelay
+	Delay time.Duration
+	// Percentile, between 0 and 1, sets the delay to that percentile of
+	// the latencies observed by the client, 0.95 for instance
+	Percentile float64
+	// MinSamples is the number of latencies observed before Percentile
+	// applies, DefaultHedgeMinSamples by default
+	MinSamples int
+	// MaxHedges is the number of extra copies, 1 by default and at most 2
+	MaxHedges int
+	// AllowNonIdempotent allows hedging methods such as POST and PATCH
+	// that do not carry an Idempotency-Key header
+	AllowNonIdempotent bool
+}
+
+// SetHedge hedges the requests of the client according to the policy
+func SetHedge(policy HedgePolicy) Option {
+	return func(o *options) {
+		o.hedge = &policy
+	}
+}
+
+// SetRequestHedge overrides the client hedging policy for the request
+func SetRequestHedge(policy HedgePolicy) RequestOption {
+	return func(o *requestOptions) {
+		o.hedge = &policy
+	}
+}
+
+type hedger struct {
+	policy *HedgePolicy
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+type hedgeResult struct {
+	attempt int
+	resp    Responser
+	err     error
+	latency time.Duration
+}
+
+func (r *hedgeResult) succeeded() bool {
+	return r.err == nil && r.resp.StatusCode() < 500
+}
+
+// discard drains and closes the response of a losing copy
+func (r *hedgeResult) discard() {
+	if r.err == nil {
+		drainBody(r.resp.Response().Body)
+	}
+}
+
+// hedgedResponse records which copy of a hedged request won
+type hedgedResponse struct {
+	Responser
+	attempt int
+}
+
+func (r *hedgedResponse) Attempt() int {
+	return r.attempt
+}
+
+func (r *hedgedResponse) Decode(v interface{}) error {
+	return Decode(r.Responser, v)
+}
+
+func (h *hedger) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		p := h.policy
+		if rp, ok := ctx.Value(hedgeKey{}).(*HedgePolicy); ok {
+			p = rp
+		}
+		if p == nil || (!p.AllowNonIdempotent && !isIdempotent(req)) {
+			return next(ctx, req)
+		}
+		if err := rewindableBody(req); err != nil {
+			return nil, err
+		}
+
+		copies := 1 + p.MaxHedges
+		if p.MaxHedges <= 0 {
+			copies = 2
+		} else if p.MaxHedges > maxHedges {
+			copies = 1 + maxHedges
+		}
+		delay := h.delay(p)
+
+		results := make(chan hedgeResult, copies)
+		cancels := make([]context.CancelFunc, 0, copies)
+		launch := func() error {
+			actx, cancel := context.WithCancel(ctx)
+			areq := req.Clone(actx)
+			if len(cancels) > 0 && req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					cancel()
+					return err
+				}
+				areq.Body = body
+			}
+			cancels = append(cancels, cancel)
+
+			attempt, start := len(cancels), time.Now()
+			go func() {
+				resp, err := next(actx, areq)
+				results <- hedgeResult{attempt: attempt, resp: resp, err: err, latency: time.Since(start)}
+			}()
+			return nil
+		}
+
+		if err := launch(); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		pending := 1
+		var last *hedgeResult
+		for {
+			select {
+			case <-timer.C:
+				if len(cancels) < copies && launch() == nil {
+					pending++
+					timer.Reset(delay)
+				}
+
+			case res := <-results:
+				pending--
+				if res.succeeded() {
+					h.observe(res.latency)
+					if last != nil {
+						last.discard()
+					}
+					return h.settle(&res, cancels, results, pending), nil
+				}
+
+				if last != nil {
+					last.discard()
+				}
+				last = &res
+				if len(cancels) < copies && ctx.Err() == nil && launch() == nil {
+					pending++
+					timer.Reset(delay)
+				} else if pending == 0 {
+					return h.settle(last, cancels, results, 0), last.err
+				}
+			}
+		}
+	}
+}
+
+// settle cancels every copy but the one of res, draining the pending ones
+// in the background, and ties the context of res to its body
+func (h *hedger) settle(res *hedgeResult, cancels []context.CancelFunc, results chan hedgeResult, pending int) Responser {
+	for i, cancel := range cancels {
+		if i != res.attempt-1 {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		go func() {
+			for ; pending > 0; pending-- {
+				r := <-results
+				r.discard()
+			}
+		}()
+	}
+
+	cancel := cancels[res.attempt-1]
+	if res.err != nil {
+		cancel()
+		return nil
+	}
+	if hr := res.resp.Response(); hr.Body == nil {
+		cancel()
+	} else {
+		hr.Body = &cancelBody{ReadCloser: hr.Body, cancel: cancel}
+	}
+	return &hedgedResponse{Responser: res.resp, attempt: res.attempt}
+}
+
+// delay returns the delay before sending an extra copy
+func (h *hedger) delay(p *HedgePolicy) time.Duration {
+	if p.Percentile > 0 && p.Percentile <= 1 {
+		minSamples := p.MinSamples
+		if minSamples <= 0 {
+			minSamples = DefaultHedgeMinSamples
+		}
+
+		h.mu.Lock()
+		samples := append([]time.Duration(nil), h.latencies...)
+		h.mu.Unlock()
+
+		if len(samples) >= minSamples {
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+			i := int(p.Percentile*float64(len(samples))+0.5) - 1
+			if i < 0 {
+				i = 0
+			}
+			return samples[i]
+		}
+	}
+
+	if p.Delay > 0 {
+		return p.Delay
+	}
+	return DefaultHedgeDelay
+}
+
+// observe records the latency of a successful copy
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < hedgeSamples {
+		h.latencies = append(h.latencies, d)
+		return
+	}
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % hedgeSamples
+}
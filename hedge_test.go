@@ -0,0 +1,158 @@
+package req
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHedge(t *testing.T) {
+	var (
+		hits     int32
+		canceled int32
+		mu       sync.Mutex
+		plan     []string
+		bodies   []string
+	)
+	setPlan := func(p ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		plan, bodies = p, nil
+		atomic.StoreInt32(&hits, 0)
+		atomic.StoreInt32(&canceled, 0)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		step := "fast"
+		if int(n) <= len(plan) {
+			step = plan[n-1]
+		}
+		mu.Unlock()
+
+		switch step {
+		case "slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				atomic.AddInt32(&canceled, 1)
+				return
+			}
+		case "fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(step))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r := New(SetBaseURL(ts.URL), SetHedge(HedgePolicy{Delay: 20 * time.Millisecond}))
+
+	Convey("A slow request is hedged", t, func() {
+		setPlan("slow")
+		start := time.Now()
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldEqual, "fast")
+		So(Attempt(resp), ShouldEqual, 2)
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+
+		// the losing copy is canceled
+		for i := 0; i < 100 && atomic.LoadInt32(&canceled) == 0; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		So(atomic.LoadInt32(&canceled), ShouldEqual, 1)
+	})
+
+	Convey("A fast request is not hedged", t, func() {
+		setPlan()
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		So(Attempt(resp), ShouldEqual, 1)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+	})
+
+	Convey("A failed copy triggers the next one at once", t, func() {
+		setPlan("fail")
+		r := New(SetBaseURL(ts.URL), SetHedge(HedgePolicy{Delay: time.Minute}))
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+		So(Attempt(resp), ShouldEqual, 2)
+
+		Convey("and the last failure is returned when all fail", func() {
+			setPlan("fail", "fail")
+			resp, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, http.StatusServiceUnavailable)
+			So(Attempt(resp), ShouldEqual, 2)
+
+			setPlan("fail", "fail")
+			_, err = r.Get(ctx, "/", nil, SetStatusError(true))
+			So(err, ShouldHaveSameTypeAs, &StatusError{})
+		})
+	})
+
+	Convey("Up to two extra copies", t, func() {
+		setPlan("slow", "slow")
+		r := New(SetBaseURL(ts.URL), SetHedge(HedgePolicy{Delay: 20 * time.Millisecond, MaxHedges: 2}))
+		resp, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		So(Attempt(resp), ShouldEqual, 3)
+		resp.Close()
+	})
+
+	Convey("Non-idempotent requests are not hedged unless allowed", t, func() {
+		setPlan("slow")
+		resp, err := r.Post(ctx, "/", strings.NewReader("payload"))
+		So(err, ShouldBeNil)
+		So(Attempt(resp), ShouldEqual, 1)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+
+		setPlan("slow")
+		resp, err = r.Post(ctx, "/", strings.NewReader("payload"),
+			SetRequestHedge(HedgePolicy{Delay: 20 * time.Millisecond, AllowNonIdempotent: true}))
+		So(err, ShouldBeNil)
+		So(Attempt(resp), ShouldEqual, 2)
+		mu.Lock()
+		So(bodies, ShouldResemble, []string{"payload", "payload"})
+		mu.Unlock()
+	})
+
+	Convey("Per request hedging", t, func() {
+		setPlan("slow")
+		plain := New(SetBaseURL(ts.URL))
+		resp, err := plain.Get(ctx, "/", nil, SetRequestHedge(HedgePolicy{Delay: 20 * time.Millisecond}))
+		So(err, ShouldBeNil)
+		So(Attempt(resp), ShouldEqual, 2)
+	})
+
+	Convey("Percentile delay", t, func() {
+		h := &hedger{}
+		p := &HedgePolicy{Delay: time.Second, Percentile: 0.9, MinSamples: 10}
+		for i := 1; i <= 9; i++ {
+			h.observe(time.Duration(i) * time.Millisecond)
+		}
+		So(h.delay(p), ShouldEqual, time.Second)
+
+		h.observe(10 * time.Millisecond)
+		So(h.delay(p), ShouldEqual, 9*time.Millisecond)
+
+		for i := 0; i < hedgeSamples; i++ {
+			h.observe(time.Millisecond)
+		}
+		So(h.delay(p), ShouldEqual, time.Millisecond)
+	})
+}
@@ -0,0 +1,68 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrNilResponse is returned when a handler returns neither a response nor
+// an error, such as a middleware short-circuiting without a Responser
+var ErrNilResponse = errors.New("req: handler returned a nil response without an error")
+
+// Handler performs a single HTTP round trip
+type Handler func(ctx context.Context, req *http.Request) (Responser, error)
+
+// Middleware wraps a Handler with additional behaviour.
+//
+// Middlewares registered on the client with Use run before the ones
+// registered on a single request with SetMiddleware, and within each
+// group they run in the order they were added, so the first one is the
+// outermost. A middleware may short-circuit the chain by returning its
+// own Responser (see NewResponse) without calling next; any error it
+// returns is passed back unchanged to the caller of Do.
+type Middleware func(next Handler) Handler
+
+// Use adds middlewares that wrap every request made by the client
+func Use(mw ...Middleware) Option {
+	return func(o *options) {
+		o.middlewares = append(o.middlewares[:len(o.middlewares):len(o.middlewares)], mw...)
+	}
+}
+
+// UseOnly replaces the middlewares of the client, which is mostly useful
+// with Requester.With to drop the middlewares of the parent client
+func UseOnly(mw ...Middleware) Option {
+	return func(o *options) {
+		o.middlewares = append([]Middleware(nil), mw...)
+	}
+}
+
+// SetMiddleware adds middlewares that wrap only the current request
+func SetMiddleware(mw ...Middleware) RequestOption {
+	return func(o *requestOptions) {
+		o.middlewares = append(o.middlewares, mw...)
+	}
+}
+
+func chain(h Handler, mws ...[]Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		for j := len(mws[i]) - 1; j >= 0; j-- {
+			h = mws[i][j](h)
+		}
+	}
+	return h
+}
+
+// httpResponse returns the *http.Response of resp, failing with
+// ErrNilResponse when there is none
+func httpResponse(resp Responser) (*http.Response, error) {
+	if resp == nil {
+		return nil, ErrNilResponse
+	}
+	res := resp.Response()
+	if res == nil {
+		return nil, ErrNilResponse
+	}
+	return res, nil
+}
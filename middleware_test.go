@@ -0,0 +1,104 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMiddleware(t *testing.T) {
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, r.Header.Get("X-Trace"))
+	}))
+	defer ts.Close()
+
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req *http.Request) (Responser, error) {
+				req.Header.Set("X-Trace", req.Header.Get("X-Trace")+name+">")
+				return next(ctx, req)
+			}
+		}
+	}
+
+	Convey("Test Middleware Order", t, func() {
+		r := New(Use(trace("c1"), trace("c2")))
+
+		resp, err := r.Get(context.Background(), ts.URL, nil, SetMiddleware(trace("r1")))
+		So(err, ShouldBeNil)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "c1>c2>r1>")
+	})
+
+	Convey("Test Middleware Short Circuit", t, func() {
+		hits = 0
+		r := New(Use(func(next Handler) Handler {
+			return func(ctx context.Context, req *http.Request) (Responser, error) {
+				return NewResponse(&http.Response{
+					StatusCode: http.StatusTeapot,
+					Header:     make(http.Header),
+					Body:       ioutil.NopCloser(strings.NewReader("cached")),
+					Request:    req,
+				}), nil
+			}
+		}))
+
+		resp, err := r.Get(context.Background(), ts.URL, nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusTeapot)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "cached")
+		So(hits, ShouldEqual, 0)
+	})
+
+	Convey("Test Middleware Nil Response", t, func() {
+		r := New(Use(func(next Handler) Handler {
+			return func(ctx context.Context, req *http.Request) (Responser, error) {
+				return nil, nil
+			}
+		}))
+
+		resp, err := r.Get(context.Background(), ts.URL, nil)
+		So(err, ShouldEqual, ErrNilResponse)
+		So(resp, ShouldBeNil)
+	})
+
+	Convey("Test Middleware Error", t, func() {
+		errDenied := errors.New("denied")
+		var inner bool
+		r := New(Use(func(next Handler) Handler {
+			return func(ctx context.Context, req *http.Request) (Responser, error) {
+				resp, err := next(ctx, req)
+				So(err, ShouldEqual, errDenied)
+				return resp, err
+			}
+		}))
+
+		resp, err := r.Get(context.Background(), ts.URL, nil, SetMiddleware(func(next Handler) Handler {
+			return func(ctx context.Context, req *http.Request) (Responser, error) {
+				return nil, errDenied
+			}
+		}, func(next Handler) Handler {
+			return func(ctx context.Context, req *http.Request) (Responser, error) {
+				inner = true
+				return next(ctx, req)
+			}
+		}))
+		So(err, ShouldEqual, errDenied)
+		So(resp, ShouldBeNil)
+		So(inner, ShouldBeFalse)
+	})
+}
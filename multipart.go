@@ -0,0 +1,314 @@
+package req
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var errPartConsumed = errors.New("req: multipart reader already consumed")
+
+// Multipart builds a multipart/form-data body that is streamed to the
+// server instead of being buffered in memory
+type Multipart struct {
+	boundary string
+	parts    []*multipartPart
+}
+
+type multipartPart struct {
+	header textproto.MIMEHeader
+	// open returns the part content; parts built from a reader can only
+	// be opened once
+	open     func() (io.ReadCloser, error)
+	stat     func() (int64, error)
+	size     int64
+	reusable bool
+}
+
+// PartOption customizes a part of a Multipart body
+type PartOption func(textproto.MIMEHeader)
+
+// SetPartContentType sets the Content-Type of the part
+func SetPartContentType(contentType string) PartOption {
+	return func(h textproto.MIMEHeader) {
+		h.Set(HeaderContentType, contentType)
+	}
+}
+
+// SetPartHeader sets a header of the part
+func SetPartHeader(key, value string) PartOption {
+	return func(h textproto.MIMEHeader) {
+		h.Set(key, value)
+	}
+}
+
+// NewMultipart creates an empty multipart/form-data body
+func NewMultipart() *Multipart {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	return &Multipart{boundary: fmt.Sprintf("%x", buf[:])}
+}
+
+// ContentType returns the Content-Type of the body, including its boundary
+func (m *Multipart) ContentType() string {
+	return MIMEMultipartForm + "; boundary=" + m.boundary
+}
+
+// Field adds a form field
+func (m *Multipart) Field(name, value string, opts ...PartOption) *Multipart {
+	h := make(textproto.MIMEHeader)
+	h.Set(HeaderContentDisposition, fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(name)))
+	return m.add(h, opts, &multipartPart{
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(value)), nil
+		},
+		size:     int64(len(value)),
+		reusable: true,
+	})
+}
+
+// File adds the file at path as the field, the Content-Type is guessed
+// from its extension
+func (m *Multipart) File(field, path string, opts ...PartOption) *Multipart {
+	return m.add(fileHeader(field, filepath.Base(path)), opts, &multipartPart{
+		open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+		stat: func() (int64, error) {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return 0, err
+			}
+			return fi.Size(), nil
+		},
+		reusable: true,
+	})
+}
+
+// FS adds the file name of fsys as the field, the Content-Type is guessed
+// from its extension
+func (m *Multipart) FS(fsys fs.FS, field, name string, opts ...PartOption) *Multipart {
+	return m.add(fileHeader(field, path.Base(name)), opts, &multipartPart{
+		open: func() (io.ReadCloser, error) {
+			return fsys.Open(name)
+		},
+		stat: func() (int64, error) {
+			fi, err := fs.Stat(fsys, name)
+			if err != nil {
+				return 0, err
+			}
+			return fi.Size(), nil
+		},
+		reusable: true,
+	})
+}
+
+// Reader adds the content of r as a file field. size is the length of
+// the content, or -1 when unknown which disables Content-Length
+func (m *Multipart) Reader(field, filename string, r io.Reader, size int64, opts ...PartOption) *Multipart {
+	return m.Part(fileHeader(field, filename), r, size, opts...)
+}
+
+// Part adds a part with a custom header
+func (m *Multipart) Part(header textproto.MIMEHeader, r io.Reader, size int64, opts ...PartOption) *Multipart {
+	var used int32
+	return m.add(header, opts, &multipartPart{
+		open: func() (io.ReadCloser, error) {
+			if !atomic.CompareAndSwapInt32(&used, 0, 1) {
+				return nil, errPartConsumed
+			}
+			return ioutil.NopCloser(r), nil
+		},
+		size: size,
+	})
+}
+
+func (m *Multipart) add(h textproto.MIMEHeader, opts []PartOption, p *multipartPart) *Multipart {
+	if h == nil {
+		h = make(textproto.MIMEHeader)
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	p.header = h
+	m.parts = append(m.parts, p)
+	return m
+}
+
+// prepare resolves the part sizes and returns the body length, or -1
+// when a part size is unknown
+func (m *Multipart) prepare() (int64, error) {
+	cw := new(countWriter)
+	mw := multipart.NewWriter(cw)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, p := range m.parts {
+		if p.stat != nil {
+			size, err := p.stat()
+			if err != nil {
+				return 0, err
+			}
+			p.size = size
+		}
+		if p.size < 0 {
+			total = -1
+		}
+		if total >= 0 {
+			total += p.size
+		}
+		if _, err := mw.CreatePart(p.header); err != nil {
+			return 0, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return 0, err
+	}
+
+	if total < 0 {
+		return -1, nil
+	}
+	return total + cw.n, nil
+}
+
+func (m *Multipart) reusable() bool {
+	for _, p := range m.parts {
+		if !p.reusable {
+			return false
+		}
+	}
+	return true
+}
+
+// body returns a reader streaming the encoded parts, the encoding starts
+// on the first read
+func (m *Multipart) body() io.ReadCloser {
+	return &lazyPipe{write: m.writeTo}
+}
+
+func (m *Multipart) writeTo(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+
+	for _, p := range m.parts {
+		pw, err := mw.CreatePart(p.header)
+		if err != nil {
+			return err
+		}
+
+		rc, err := p.open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(pw, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func (r *request) doMultipart(ctx context.Context, urlStr, method string, body *Multipart, opts ...RequestOption) (Responser, error) {
+	size, err := body.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	var ro []RequestOption
+	ro = append(ro, SetContentType(body.ContentType()), func(o *requestOptions) {
+		if size >= 0 {
+			o.request.ContentLength = size
+		}
+		if body.reusable() {
+			o.request.GetBody = func() (io.ReadCloser, error) {
+				return body.body(), nil
+			}
+		}
+	})
+	if len(opts) > 0 {
+		ro = append(ro, opts...)
+	}
+	return r.Do(ctx, urlStr, method, body.body(), ro...)
+}
+
+func fileHeader(field, filename string) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	h.Set(HeaderContentDisposition, fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(field), escapeQuotes(filename)))
+
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = MIMEOctetStream
+	}
+	h.Set(HeaderContentType, contentType)
+	return h
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// lazyPipe runs write in a goroutine feeding a pipe once the first read
+// happens, so that an unsent body does not leak the goroutine
+type lazyPipe struct {
+	write func(io.Writer) error
+	once  sync.Once
+	pr    *io.PipeReader
+}
+
+func (p *lazyPipe) start() {
+	p.once.Do(func() {
+		pr, pw := io.Pipe()
+		p.pr = pr
+		go func() {
+			pw.CloseWithError(p.write(pw))
+		}()
+	})
+}
+
+func (p *lazyPipe) Read(b []byte) (int, error) {
+	p.start()
+	// closed before the first read
+	if p.pr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return p.pr.Read(b)
+}
+
+func (p *lazyPipe) Close() error {
+	p.once.Do(func() {})
+	if p.pr == nil {
+		return nil
+	}
+	return p.pr.Close()
+}
@@ -0,0 +1,111 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultipart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.ContentLength >= 0 && int64(len(body)) != r.ContentLength {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "Not expected value,ContentLength:%d,Body:%d", r.ContentLength, len(body))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		fmt.Fprintf(w, "%s %d", r.Method, r.ContentLength)
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			b, _ := ioutil.ReadAll(p)
+			fmt.Fprintf(w, "|%s,%s,%s,%s,%s", p.FormName(), p.FileName(), p.Header.Get(HeaderContentType), p.Header.Get("X-Part"), b)
+		}
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("file content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{"dir/b.json": {Data: []byte(`{"b":1}`)}}
+
+	Convey("Test Multipart Known Length", t, func() {
+		r := New()
+
+		mp := NewMultipart().
+			Field("name", "foo").
+			File("a", path).
+			FS(fsys, "b", "dir/b.json", SetPartHeader("X-Part", "bar")).
+			Reader("c", "c.bin", strings.NewReader("xyz"), 3, SetPartContentType(MIMETextPlain))
+
+		resp, err := r.PostMultipart(context.Background(), ts.URL, mp)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		parts := strings.Split(body, "|")
+		So(parts[0], ShouldStartWith, "POST ")
+		So(parts[0], ShouldNotEqual, "POST -1")
+		So(parts[1:], ShouldResemble, []string{
+			"name,,,,foo",
+			"a,a.txt,text/plain; charset=utf-8,,file content",
+			`b,b.json,application/json,bar,{"b":1}`,
+			"c,c.bin,text/plain,,xyz",
+		})
+	})
+
+	Convey("Test Multipart Unknown Length", t, func() {
+		r := New()
+
+		mp := NewMultipart().
+			Field("name", "foo").
+			Reader("c", "c.bin", strings.NewReader("xyz"), -1)
+
+		resp, err := r.PutMultipart(context.Background(), ts.URL, mp)
+		So(err, ShouldBeNil)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "PUT -1|name,,,,foo|c,c.bin,application/octet-stream,,xyz")
+	})
+
+	Convey("Test Multipart Missing File", t, func() {
+		r := New()
+
+		_, err := r.PostMultipart(context.Background(), ts.URL, NewMultipart().File("a", path+".missing"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+	Convey("Test Multipart Read After Close", t, func() {
+		p := &lazyPipe{write: func(w io.Writer) error {
+			_, err := io.WriteString(w, "data")
+			return err
+		}}
+		So(p.Close(), ShouldBeNil)
+		_, err := p.Read(make([]byte, 4))
+		So(err, ShouldEqual, io.ErrClosedPipe)
+	})
+}
@@ -3,6 +3,7 @@ package req
 import (
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -15,12 +16,17 @@ const (
 	MIMEApplicationXML                   = "application/xml"
 	MIMEApplicationXMLCharsetUTF8        = "application/xml; charset=utf-8"
 	MIMEApplicationForm                  = "application/x-www-form-urlencoded"
+	MIMEApplicationProblemJSON           = "application/problem+json"
+	MIMEApplicationJSONPatch             = "application/json-patch+json"
+	MIMEApplicationMergePatch            = "application/merge-patch+json"
+	MIMEApplicationNDJSON                = "application/x-ndjson"
 	MIMEApplicationProtobuf              = "application/protobuf"
 	MIMEApplicationMsgpack               = "application/msgpack"
 	MIMETextHTML                         = "text/html"
 	MIMETextHTMLCharsetUTF8              = "text/html; charset=utf-8"
 	MIMETextPlain                        = "text/plain"
 	MIMETextPlainCharsetUTF8             = "text/plain; charset=utf-8"
+	MIMETextEventStream                  = "text/event-stream"
 	MIMEMultipartForm                    = "multipart/form-data"
 	MIMEOctetStream                      = "application/octet-stream"
 )
@@ -55,6 +61,13 @@ const (
 	HeaderAcceptDatetime     = "Accept-Datetime"     // Requests
 	HeaderXRequestedWith     = "X-Requested-With"    // Requests
 	HeaderXRequestID         = "X-Request-ID"        // Requests
+	HeaderLastEventID        = "Last-Event-ID"       // Requests
+	HeaderDepth              = "Depth"               // Requests
+	HeaderDestination        = "Destination"         // Requests
+	HeaderOverwrite          = "Overwrite"           // Requests
+	HeaderIf                 = "If"                  // Requests
+	HeaderTimeout            = "Timeout"             // Requests
+	HeaderLockToken          = "Lock-Token"          // Requests, Responses
 
 	HeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"      // Responses
 	HeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"     // Responses
@@ -71,6 +84,7 @@ const (
 	HeaderContentLanguage               = "Content-Language"                 // Responses
 	HeaderContentLocation               = "Content-Location"                 // Responses
 	HeaderContentDisposition            = "Content-Disposition"              // Responses
+	HeaderDAV                           = "DAV"                              // Responses
 	HeaderContentRange                  = "Content-Range"                    // Responses
 	HeaderETag                          = "ETag"                             // Responses
 	HeaderExpires                       = "Expires"                          // Responses
@@ -133,11 +147,33 @@ type options struct {
 	timeout       time.Duration
 	baseURL       string
 	header        http.Header
+	query         url.Values
+	middlewares   []Middleware
+	retry         *RetryPolicy
+	statusError   bool
+	codecs        map[string]Codec
+	cache         CacheStore
+	rateLimiter   *RateLimiter
+	breaker       *CircuitBreaker
+	hedge         *HedgePolicy
+	balancer      *Balancer
+	discovery     *Discovery
+	concurrency   *ConcurrencyLimiter
 }
 
 // Option parameter options
 type Option func(*options)
 
+// clone copies o so that options applied to the copy leave o unchanged
+func (o options) clone() options {
+	o.header = o.header.Clone()
+	if o.query != nil {
+		o.query = cloneValues(o.query)
+	}
+	o.middlewares = append([]Middleware(nil), o.middlewares...)
+	return o
+}
+
 // SetBaseURL set the requested base url
 func SetBaseURL(base string) Option {
 	return func(o *options) {
@@ -186,8 +222,24 @@ func SetTimeout(d time.Duration) Option {
 }
 
 type requestOptions struct {
-	request *http.Request
-	handle  func(req *http.Request) (*http.Request, error)
+	request     *http.Request
+	handle      func(req *http.Request) (*http.Request, error)
+	middlewares []Middleware
+	statusError *bool
+	err         error
+
+	query      []string
+	pathParams map[string]string
+
+	baseURL         string
+	timeout         time.Duration
+	checkRedirect   func(req *http.Request, via []*http.Request) error
+	maxResponseSize int64
+	hedge           *HedgePolicy
+
+	uploadProgress   func(Progress)
+	downloadProgress func(Progress)
+	progressInterval time.Duration
 }
 
 // RequestOption request parameter options
@@ -0,0 +1,104 @@
+package req
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds the limit
+// set with SetMaxResponseSize
+var ErrResponseTooLarge = errors.New("req: response body too large")
+
+// redirectKey carries the per-request redirect policy in the context
+type redirectKey struct{}
+
+// NoRedirect is a redirect policy that does not follow redirects, the
+// redirect response itself being returned
+func NoRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// SetRequestTimeout sets a time limit for the request, including reading
+// the response body. It is enforced through the request context and
+// applies on top of the client timeout
+func SetRequestTimeout(d time.Duration) RequestOption {
+	return func(o *requestOptions) {
+		o.timeout = d
+	}
+}
+
+// SetRequestBaseURL overrides the client base url for the request
+func SetRequestBaseURL(base string) RequestOption {
+	return func(o *requestOptions) {
+		o.baseURL = base
+	}
+}
+
+// SetRequestCheckRedirect overrides the client redirect policy for the
+// request, use NoRedirect to not follow redirects
+func SetRequestCheckRedirect(redirect func(req *http.Request, via []*http.Request) error) RequestOption {
+	return func(o *requestOptions) {
+		o.checkRedirect = redirect
+	}
+}
+
+// SetMaxResponseSize limits the size of the response body. The request
+// fails with ErrResponseTooLarge when the announced Content-Length is
+// larger, otherwise reading the body fails once the limit is crossed
+func SetMaxResponseSize(n int64) RequestOption {
+	return func(o *requestOptions) {
+		o.maxResponseSize = n
+	}
+}
+
+// checkRedirect applies the redirect policy of the request, or else the
+// one of the client, or else the http.Client default of 10 redirects
+func (r *request) checkRedirect(req *http.Request, via []*http.Request) error {
+	if fn, ok := req.Context().Value(redirectKey{}).(func(*http.Request, []*http.Request) error); ok {
+		return fn(req, via)
+	}
+	if fn := r.opts.checkRedirect; fn != nil {
+		return fn(req, via)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+func (ro *requestOptions) limitResponse(res *http.Response) error {
+	n := ro.maxResponseSize
+	if n <= 0 || res.Body == nil {
+		return nil
+	}
+	if res.ContentLength > n {
+		drainBody(res.Body)
+		return ErrResponseTooLarge
+	}
+	res.Body = &limitedBody{ReadCloser: res.Body, n: n}
+	return nil
+}
+
+// limitedBody fails with ErrResponseTooLarge instead of returning more
+// than n bytes
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return n + int(b.n), ErrResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,105 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestOverrides(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		case "/redirect":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		case "/chunked":
+			for i := 0; i < 10; i++ {
+				fmt.Fprint(w, strings.Repeat("x", 10))
+				w.(http.Flusher).Flush()
+			}
+		default:
+			fmt.Fprint(w, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	Convey("Test Request Timeout", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		start := time.Now()
+		_, err := r.Get(context.Background(), "/slow", nil, SetRequestTimeout(50*time.Millisecond))
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(r.(*request).cli.Timeout, ShouldEqual, 0)
+
+		resp, err := r.Get(context.Background(), "/fast", nil, SetRequestTimeout(time.Second))
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "/fast")
+	})
+
+	Convey("Test Request Base URL", t, func() {
+		r := New(SetBaseURL("http://127.0.0.1:1/unused"))
+
+		resp, err := r.Get(context.Background(), "/path", nil, SetRequestBaseURL(ts.URL+"/base"))
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "/base/path")
+	})
+
+	Convey("Test Request Redirect", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		resp, err := r.Get(context.Background(), "/redirect", nil)
+		So(err, ShouldBeNil)
+		body, _ := resp.String()
+		So(body, ShouldEqual, "/target")
+
+		resp, err = r.Get(context.Background(), "/redirect", nil, SetRequestCheckRedirect(NoRedirect))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusFound)
+		So(resp.Response().Header.Get(HeaderLocation), ShouldEqual, "/target")
+		resp.Close()
+
+		errDenied := errors.New("denied")
+		_, err = r.Get(context.Background(), "/redirect", nil, SetRequestCheckRedirect(func(req *http.Request, via []*http.Request) error {
+			return errDenied
+		}))
+		So(errors.Is(err, errDenied), ShouldBeTrue)
+	})
+
+	Convey("Test Max Response Size", t, func() {
+		r := New(SetBaseURL(ts.URL))
+
+		_, err := r.Get(context.Background(), "/large", nil, SetMaxResponseSize(50))
+		So(err, ShouldEqual, ErrResponseTooLarge)
+
+		resp, err := r.Get(context.Background(), "/large", nil, SetMaxResponseSize(100))
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(len(body), ShouldEqual, 100)
+
+		resp, err = r.Get(context.Background(), "/chunked", nil, SetMaxResponseSize(50))
+		So(err, ShouldBeNil)
+		b, err := ioutil.ReadAll(resp.Response().Body)
+		So(err, ShouldEqual, ErrResponseTooLarge)
+		So(len(b), ShouldEqual, 50)
+	})
+}
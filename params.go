@@ -0,0 +1,297 @@
+package req
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParamEncoder is implemented by types that encode themselves as a query,
+// header or path parameter
+type ParamEncoder interface {
+	EncodeParam() (string, error)
+}
+
+var (
+	paramEncoderType  = reflect.TypeOf((*ParamEncoder)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+)
+
+// SetParams encodes the fields of the struct v tagged with `query`,
+// `header` and `path` into the query string, the headers and the path
+// template of the request:
+//
+//	type ListUsers struct {
+//		Org    string    `path:"org"`
+//		Tenant string    `header:"X-Tenant"`
+//		Page   int       `query:"page,omitempty"`
+//		Tags   []string  `query:"tag"`
+//		IDs    []int     `query:"ids,comma"`
+//		Since  time.Time `query:"since,omitempty" layout:"2006-01-02"`
+//	}
+//	r.Get(ctx, "/orgs/{org}/users", nil, req.SetParams(ListUsers{...}))
+//
+// Slices produce one parameter per element unless the comma option joins
+// them. time.Time values are formatted with the layout tag, which also
+// accepts "unix" and "unixmilli", or RFC 3339. Types implementing
+// ParamEncoder or encoding.TextMarshaler encode themselves, and embedded
+// structs are flattened
+func SetParams(v interface{}) RequestOption {
+	return func(o *requestOptions) {
+		if o.err != nil {
+			return
+		}
+
+		query, err := EncodeQuery(v)
+		if err != nil {
+			o.err = err
+			return
+		}
+		header, err := EncodeHeader(v)
+		if err != nil {
+			o.err = err
+			return
+		}
+		path, err := EncodePath(v)
+		if err != nil {
+			o.err = err
+			return
+		}
+
+		if len(query) > 0 {
+			o.query = append(o.query, query.Encode())
+		}
+		for k, vs := range header {
+			o.request.Header[k] = vs
+		}
+		for k, s := range path {
+			if o.pathParams == nil {
+				o.pathParams = make(map[string]string)
+			}
+			o.pathParams[k] = s
+		}
+	}
+}
+
+// EncodeQuery encodes the fields of the struct v tagged with `query`
+func EncodeQuery(v interface{}) (url.Values, error) {
+	values := make(url.Values)
+	err := encodeParams(v, "query", func(name string, vs []string) error {
+		values[name] = append(values[name], vs...)
+		return nil
+	})
+	return values, err
+}
+
+// EncodeHeader encodes the fields of the struct v tagged with `header`
+func EncodeHeader(v interface{}) (http.Header, error) {
+	header := make(http.Header)
+	err := encodeParams(v, "header", func(name string, vs []string) error {
+		for _, s := range vs {
+			header.Add(name, s)
+		}
+		return nil
+	})
+	return header, err
+}
+
+// EncodePath encodes the fields of the struct v tagged with `path`
+func EncodePath(v interface{}) (map[string]string, error) {
+	path := make(map[string]string)
+	err := encodeParams(v, "path", func(name string, vs []string) error {
+		if len(vs) != 1 {
+			return fmt.Errorf("req: path parameter %q must have a single value", name)
+		}
+		path[name] = vs[0]
+		return nil
+	})
+	return path, err
+}
+
+func encodeParams(v interface{}, tag string, add func(name string, vs []string) error) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("req: cannot encode %T parameters, a struct is required", v)
+	}
+	return encodeStruct(rv, tag, add)
+}
+
+func encodeStruct(rv reflect.Value, tag string, add func(name string, vs []string) error) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+
+		name, opts, tagged := parseParamTag(sf.Tag.Get(tag))
+		if name == "-" {
+			continue
+		}
+		if sf.Anonymous && !tagged {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && !isParamValue(fv.Type()) {
+				if err := encodeStruct(fv, tag, add); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !tagged || sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		if opts.contains("omitempty") && fv.IsZero() {
+			continue
+		}
+
+		vs, err := paramValues(fv, sf.Tag.Get("layout"))
+		if err != nil {
+			return fmt.Errorf("req: %s parameter %q: %w", tag, name, err)
+		}
+		if vs == nil {
+			continue
+		}
+		if opts.contains("comma") {
+			vs = []string{strings.Join(vs, ",")}
+		}
+		if err := add(name, vs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paramValues formats a field, returning nil for nil pointers and
+// interfaces
+func paramValues(fv reflect.Value, layout string) ([]string, error) {
+	for fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface {
+		if fv.IsNil() {
+			return nil, nil
+		}
+		if fv.Type().Implements(paramEncoderType) {
+			break
+		}
+		fv = fv.Elem()
+	}
+
+	if !isParamValue(fv.Type()) {
+		switch fv.Kind() {
+		case reflect.Slice, reflect.Array:
+			if fv.Type().Elem().Kind() == reflect.Uint8 {
+				break
+			}
+			vs := make([]string, 0, fv.Len())
+			for i := 0; i < fv.Len(); i++ {
+				s, err := paramValue(fv.Index(i), layout)
+				if err != nil {
+					return nil, err
+				}
+				vs = append(vs, s)
+			}
+			return vs, nil
+		}
+	}
+
+	s, err := paramValue(fv, layout)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+func paramValue(fv reflect.Value, layout string) (string, error) {
+	if fv.Type().Implements(paramEncoderType) {
+		return fv.Interface().(ParamEncoder).EncodeParam()
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(paramEncoderType) {
+		return fv.Addr().Interface().(ParamEncoder).EncodeParam()
+	}
+
+	if fv.Type() == timeType {
+		t := fv.Interface().(time.Time)
+		switch layout {
+		case "":
+			return t.Format(time.RFC3339), nil
+		case "unix":
+			return strconv.FormatInt(t.Unix(), 10), nil
+		case "unixmilli":
+			return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10), nil
+		}
+		return t.Format(layout), nil
+	}
+
+	if fv.Type().Implements(textMarshalerType) {
+		b, err := fv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 64), nil
+	case reflect.Slice, reflect.Array:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, fv.Len())
+			reflect.Copy(reflect.ValueOf(b), fv)
+			return string(b), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", fv.Type())
+}
+
+// isParamValue reports whether values of t encode themselves rather than
+// being flattened or expanded
+func isParamValue(t reflect.Type) bool {
+	return t == timeType ||
+		t.Implements(paramEncoderType) || reflect.PtrTo(t).Implements(paramEncoderType) ||
+		t.Implements(textMarshalerType)
+}
+
+type paramTagOptions string
+
+func (o paramTagOptions) contains(name string) bool {
+	for _, s := range strings.Split(string(o), ",") {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseParamTag(tag string) (string, paramTagOptions, bool) {
+	if tag == "" {
+		return "", "", false
+	}
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], paramTagOptions(tag[i+1:]), true
+	}
+	return tag, "", true
+}
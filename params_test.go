@@ -0,0 +1,104 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type sortOrder int
+
+func (s sortOrder) EncodeParam() (string, error) {
+	if s < 0 {
+		return "desc", nil
+	}
+	return "asc", nil
+}
+
+type pagination struct {
+	Page  int `query:"page,omitempty"`
+	Limit int `query:"limit,omitempty"`
+}
+
+type listUsers struct {
+	pagination
+	Org     string    `path:"org"`
+	Tenant  string    `header:"X-Tenant"`
+	Roles   []string  `header:"X-Role"`
+	Tags    []string  `query:"tag"`
+	IDs     []int     `query:"ids,comma"`
+	Since   time.Time `query:"since,omitempty" layout:"2006-01-02"`
+	Before  time.Time `query:"before" layout:"unix"`
+	Active  *bool     `query:"active"`
+	Sort    sortOrder `query:"sort"`
+	Ignored string    `query:"-"`
+	Empty   string    `query:"empty,omitempty"`
+}
+
+func TestParams(t *testing.T) {
+	active := true
+	params := listUsers{
+		pagination: pagination{Page: 2},
+		Org:        "a/b c",
+		Tenant:     "t1",
+		Roles:      []string{"admin", "dev"},
+		Tags:       []string{"x", "y"},
+		IDs:        []int{1, 2, 3},
+		Since:      time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Before:     time.Unix(1600000000, 0),
+		Active:     &active,
+		Sort:       -1,
+		Ignored:    "no",
+	}
+
+	Convey("Test Encode Query", t, func() {
+		q, err := EncodeQuery(params)
+		So(err, ShouldBeNil)
+		So(q, ShouldResemble, url.Values{
+			"page":   {"2"},
+			"tag":    {"x", "y"},
+			"ids":    {"1,2,3"},
+			"since":  {"2020-01-02"},
+			"before": {"1600000000"},
+			"active": {"true"},
+			"sort":   {"desc"},
+		})
+
+		h, err := EncodeHeader(&params)
+		So(err, ShouldBeNil)
+		So(h, ShouldResemble, http.Header{"X-Tenant": {"t1"}, "X-Role": {"admin", "dev"}})
+
+		_, err = EncodeQuery(1)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test Set Params", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s?%s %s", r.URL.EscapedPath(), r.URL.RawQuery, strings.Join(r.Header.Values("X-Role"), ","))
+		}))
+		defer ts.Close()
+
+		r := New(SetBaseURL(ts.URL))
+		resp, err := r.Get(context.Background(), "/orgs/{org}/users", url.Values{"q": {"1"}},
+			SetParams(struct {
+				Org  string `path:"org"`
+				Page int    `query:"page"`
+				Role string `header:"X-Role"`
+			}{"a/b c", 3, "dev"}))
+		So(err, ShouldBeNil)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "/orgs/a%2Fb%20c/users?q=1&page=3 dev")
+
+		_, err = r.Get(context.Background(), "/", nil, SetParams("bad"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,154 @@
+package req
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// JSONPatch is an RFC 6902 JSON Patch document. PatchJSON sends it with
+// the application/json-patch+json content type:
+//
+//	patch := req.JSONPatch{}.
+//		Test("/version", 3).
+//		Replace("/name", "foo").
+//		Remove(req.JSONPointer("tags", "a/b"))
+//	r.PatchJSON(ctx, "/items/1", patch)
+type JSONPatch []JSONPatchOperation
+
+// JSONPatchOperation is an operation of a JSON Patch document
+type JSONPatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// MarshalJSON implements json.Marshaler, the value member being written
+// only, and always, for the operations that take one
+func (o JSONPatchOperation) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{
+		"op":   o.Op,
+		"path": o.Path,
+	}
+	switch o.Op {
+	case "add", "replace", "test":
+		m["value"] = o.Value
+	case "move", "copy":
+		m["from"] = o.From
+	}
+	return json.Marshal(m)
+}
+
+// Add appends an "add" operation
+func (p JSONPatch) Add(path string, value interface{}) JSONPatch {
+	return append(p, JSONPatchOperation{Op: "add", Path: path, Value: value})
+}
+
+// Remove appends a "remove" operation
+func (p JSONPatch) Remove(path string) JSONPatch {
+	return append(p, JSONPatchOperation{Op: "remove", Path: path})
+}
+
+// Replace appends a "replace" operation
+func (p JSONPatch) Replace(path string, value interface{}) JSONPatch {
+	return append(p, JSONPatchOperation{Op: "replace", Path: path, Value: value})
+}
+
+// Move appends a "move" operation
+func (p JSONPatch) Move(from, path string) JSONPatch {
+	return append(p, JSONPatchOperation{Op: "move", From: from, Path: path})
+}
+
+// Copy appends a "copy" operation
+func (p JSONPatch) Copy(from, path string) JSONPatch {
+	return append(p, JSONPatchOperation{Op: "copy", From: from, Path: path})
+}
+
+// Test appends a "test" operation
+func (p JSONPatch) Test(path string, value interface{}) JSONPatch {
+	return append(p, JSONPatchOperation{Op: "test", Path: path, Value: value})
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSONPointer builds an RFC 6901 JSON Pointer from reference tokens,
+// escaping "~" and "/"
+func JSONPointer(tokens ...string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteByte('/')
+		b.WriteString(pointerEscaper.Replace(t))
+	}
+	return b.String()
+}
+
+// MergePatch is an RFC 7396 JSON merge patch document, a nil member
+// removing the target member. PatchJSON sends it with the
+// application/merge-patch+json content type
+type MergePatch map[string]interface{}
+
+// CreateMergePatch computes the merge patch turning original into
+// modified, both being values encoded as JSON objects
+func CreateMergePatch(original, modified interface{}) (MergePatch, error) {
+	o, err := toJSONObject(original)
+	if err != nil {
+		return nil, err
+	}
+	m, err := toJSONObject(modified)
+	if err != nil {
+		return nil, err
+	}
+	return diffObjects(o, m), nil
+}
+
+func diffObjects(original, modified map[string]interface{}) MergePatch {
+	patch := make(MergePatch)
+	for k := range original {
+		if _, ok := modified[k]; !ok {
+			patch[k] = nil
+		}
+	}
+
+	for k, mv := range modified {
+		ov, ok := original[k]
+		if ok && reflect.DeepEqual(ov, mv) {
+			continue
+		}
+
+		oo, oIsObj := ov.(map[string]interface{})
+		mo, mIsObj := mv.(map[string]interface{})
+		if ok && oIsObj && mIsObj {
+			patch[k] = diffObjects(oo, mo)
+			continue
+		}
+		// a null can only be expressed by removing the member
+		patch[k] = mv
+	}
+	return patch
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf, &m); err != nil || m == nil {
+		return nil, errors.New("req: merge patch requires values encoded as JSON objects")
+	}
+	return m, nil
+}
+
+// jsonContentType returns the content type a JSON body is sent with
+func jsonContentType(body interface{}) string {
+	switch body.(type) {
+	case JSONPatch, *JSONPatch:
+		return MIMEApplicationJSONPatch
+	case MergePatch, *MergePatch:
+		return MIMEApplicationMergePatch
+	}
+	return MIMEApplicationJSONCharsetUTF8
+}
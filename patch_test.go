@@ -0,0 +1,103 @@
+package req
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get(HeaderContentType), body)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r := New(SetBaseURL(ts.URL))
+
+	Convey("Patch and delete bodies", t, func() {
+		resp, err := r.PatchJSON(ctx, "/", map[string]int{"a": 1})
+		So(err, ShouldBeNil)
+		So(str(resp), ShouldEqual, `PATCH application/json; charset=utf-8 {"a":1}`)
+
+		resp, err = r.PatchForm(ctx, "/", url.Values{"a": {"1"}})
+		So(err, ShouldBeNil)
+		So(str(resp), ShouldEqual, "PATCH application/x-www-form-urlencoded a=1")
+
+		resp, err = r.PatchBody(ctx, "/", strings.NewReader("raw"), SetContentType(MIMETextPlain))
+		So(err, ShouldBeNil)
+		So(str(resp), ShouldEqual, "PATCH text/plain raw")
+
+		resp, err = r.DeleteJSON(ctx, "/", []int{1, 2})
+		So(err, ShouldBeNil)
+		So(str(resp), ShouldEqual, `DELETE application/json; charset=utf-8 [1,2]`)
+	})
+
+	Convey("JSON Patch", t, func() {
+		patch := JSONPatch{}.
+			Test("/version", 3).
+			Add("/tags/-", nil).
+			Replace("/name", "foo").
+			Move("/a", "/b").
+			Copy("/b", "/c").
+			Remove(JSONPointer("x~y", "a/b"))
+
+		resp, err := r.PatchJSON(ctx, "/", patch)
+		So(err, ShouldBeNil)
+		So(str(resp), ShouldEqual, "PATCH "+MIMEApplicationJSONPatch+" "+
+			`[{"op":"test","path":"/version","value":3},`+
+			`{"op":"add","path":"/tags/-","value":null},`+
+			`{"op":"replace","path":"/name","value":"foo"},`+
+			`{"from":"/a","op":"move","path":"/b"},`+
+			`{"from":"/b","op":"copy","path":"/c"},`+
+			`{"op":"remove","path":"/x~0y/a~1b"}]`)
+	})
+
+	Convey("Merge patch", t, func() {
+		type item struct {
+			Name  string            `json:"name"`
+			Tags  []string          `json:"tags,omitempty"`
+			Attrs map[string]string `json:"attrs,omitempty"`
+		}
+		original := item{Name: "a", Tags: []string{"x"}, Attrs: map[string]string{"k": "v", "d": "1"}}
+		modified := item{Name: "b", Attrs: map[string]string{"k": "v", "n": "2"}}
+
+		patch, err := CreateMergePatch(original, modified)
+		So(err, ShouldBeNil)
+		So(patch, ShouldResemble, MergePatch{
+			"name":  "b",
+			"tags":  nil,
+			"attrs": MergePatch{"d": nil, "n": "2"},
+		})
+
+		resp, err := r.PatchJSON(ctx, "/", patch)
+		So(err, ShouldBeNil)
+		body := str(resp)
+		So(body, ShouldStartWith, "PATCH "+MIMEApplicationMergePatch+" ")
+
+		var sent map[string]interface{}
+		So(json.Unmarshal([]byte(strings.SplitN(body, " ", 3)[2]), &sent), ShouldBeNil)
+		So(sent, ShouldResemble, map[string]interface{}{
+			"name":  "b",
+			"tags":  nil,
+			"attrs": map[string]interface{}{"d": nil, "n": "2"},
+		})
+
+		_, err = CreateMergePatch([]int{1}, modified)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func str(resp Responser) string {
+	s, _ := resp.String()
+	return s
+}
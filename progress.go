@@ -0,0 +1,154 @@
+package req
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultProgressInterval is the minimum time between two progress callbacks
+const DefaultProgressInterval = 100 * time.Millisecond
+
+// Progress describes the state of a transfer
+type Progress struct {
+	// Transferred is the number of bytes transferred so far
+	Transferred int64
+	// Total is the expected number of bytes, -1 when unknown
+	Total int64
+	// Rate is the average transfer rate in bytes per second
+	Rate float64
+	// Elapsed is the time since the transfer started
+	Elapsed time.Duration
+	// Done is set on the last callback, once the body is fully transferred
+	Done bool
+}
+
+// OnUploadProgress reports the progress of sending the request body
+func OnUploadProgress(fn func(Progress)) RequestOption {
+	return func(o *requestOptions) {
+		o.uploadProgress = fn
+	}
+}
+
+// OnDownloadProgress reports the progress of reading the response body
+func OnDownloadProgress(fn func(Progress)) RequestOption {
+	return func(o *requestOptions) {
+		o.downloadProgress = fn
+	}
+}
+
+// SetProgressInterval sets the minimum time between two progress
+// callbacks, DefaultProgressInterval being used otherwise
+func SetProgressInterval(d time.Duration) RequestOption {
+	return func(o *requestOptions) {
+		o.progressInterval = d
+	}
+}
+
+// uploadKey carries the upload progress callback of a request to the
+// round trip of each attempt
+type uploadKey struct{}
+
+type uploadProgress struct {
+	fn       func(Progress)
+	interval time.Duration
+}
+
+// trackUpload has the body sent by each attempt of req, rather than the
+// body buffered by the middlewares that rewind it, report its progress
+func (ro *requestOptions) trackUpload(ctx context.Context) context.Context {
+	if ro.uploadProgress == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, uploadKey{}, &uploadProgress{fn: ro.uploadProgress, interval: ro.progressInterval})
+}
+
+// track returns a copy of req whose body, and the bodies returned by
+// GetBody for transport level retries, report their progress
+func (u *uploadProgress) track(req *http.Request) *http.Request {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req
+	}
+
+	total := req.ContentLength
+	if total == 0 {
+		total = -1
+	}
+	treq := *req
+	treq.Body = newProgressReader(req.Body, total, u.interval, u.fn)
+	if getBody := req.GetBody; getBody != nil {
+		treq.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newProgressReader(body, total, u.interval, u.fn), nil
+		}
+	}
+	return &treq
+}
+
+// trackDownload wraps the response body with progress reporting
+func (ro *requestOptions) trackDownload(res *http.Response) {
+	if fn := ro.downloadProgress; fn != nil && res.Body != nil {
+		res.Body = newProgressReader(res.Body, res.ContentLength, ro.progressInterval, fn)
+	}
+}
+
+type progressReader struct {
+	rc       io.ReadCloser
+	fn       func(Progress)
+	interval time.Duration
+	total    int64
+	n        int64
+	start    time.Time
+	last     time.Time
+	done     bool
+}
+
+func newProgressReader(rc io.ReadCloser, total int64, interval time.Duration, fn func(Progress)) *progressReader {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	return &progressReader{rc: rc, fn: fn, interval: interval, total: total}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	now := time.Now()
+	if r.start.IsZero() {
+		r.start, r.last = now, now
+	}
+
+	n, err := r.rc.Read(p)
+	r.n += int64(n)
+
+	if r.done {
+		return n, err
+	}
+	if err == io.EOF || (r.total > 0 && r.n >= r.total) {
+		r.done = true
+		r.report(time.Now())
+	} else if n > 0 && now.Sub(r.last) >= r.interval {
+		r.last = now
+		r.report(now)
+	}
+	return n, err
+}
+
+func (r *progressReader) report(now time.Time) {
+	p := Progress{
+		Transferred: r.n,
+		Total:       r.total,
+		Elapsed:     now.Sub(r.start),
+		Done:        r.done,
+	}
+	if p.Elapsed > 0 {
+		p.Rate = float64(r.n) / p.Elapsed.Seconds()
+	}
+	r.fn(p)
+}
+
+func (r *progressReader) Close() error {
+	return r.rc.Close()
+}
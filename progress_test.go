@@ -0,0 +1,114 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProgress(t *testing.T) {
+	payload := strings.Repeat("x", 64<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		if n == 0 {
+			w.Header().Set(HeaderContentLength, strconv.Itoa(len(payload)))
+			io.WriteString(w, payload)
+		}
+	}))
+	defer ts.Close()
+
+	var last Progress
+	var calls int
+	record := func(p Progress) {
+		calls++
+		last = p
+	}
+
+	Convey("Test Upload Progress", t, func() {
+		r := New()
+
+		calls = 0
+		resp, err := r.PostJSON(context.Background(), ts.URL, map[string]string{"p": payload}, OnUploadProgress(record))
+		So(err, ShouldBeNil)
+		resp.Close()
+		So(calls, ShouldBeGreaterThan, 0)
+		So(last.Done, ShouldBeTrue)
+		So(last.Total, ShouldBeGreaterThan, len(payload))
+		So(last.Transferred, ShouldEqual, last.Total)
+
+		calls = 0
+		body := io.MultiReader(strings.NewReader(payload), strings.NewReader(payload))
+		resp, err = r.Post(context.Background(), ts.URL, body, OnUploadProgress(record))
+		So(err, ShouldBeNil)
+		resp.Close()
+		So(last.Done, ShouldBeTrue)
+		So(last.Total, ShouldEqual, -1)
+		So(last.Transferred, ShouldEqual, 2*len(payload))
+	})
+
+	Convey("Test Upload Progress With Retries", t, func() {
+		var attempts int32
+		flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(ioutil.Discard, r.Body)
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer flaky.Close()
+
+		r := New(SetRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+		var done []Progress
+		body := io.MultiReader(strings.NewReader(payload))
+		resp, err := r.Put(context.Background(), flaky.URL, body, OnUploadProgress(func(p Progress) {
+			if p.Done {
+				done = append(done, p)
+			}
+		}))
+		So(err, ShouldBeNil)
+		resp.Close()
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 2)
+		// each attempt reports the body it sends
+		So(done, ShouldHaveLength, 2)
+		for _, p := range done {
+			So(p.Transferred, ShouldEqual, len(payload))
+			So(p.Total, ShouldEqual, len(payload))
+		}
+	})
+
+	Convey("Test Download Progress", t, func() {
+		r := New()
+
+		calls = 0
+		resp, err := r.Get(context.Background(), ts.URL, nil, OnDownloadProgress(record), SetProgressInterval(time.Nanosecond))
+		So(err, ShouldBeNil)
+
+		var buf bytes.Buffer
+		chunk := make([]byte, 1024)
+		body := resp.Response().Body
+		for {
+			n, err := body.Read(chunk)
+			buf.Write(chunk[:n])
+			if err != nil {
+				break
+			}
+		}
+		body.Close()
+
+		So(buf.Len(), ShouldEqual, len(payload))
+		So(calls, ShouldBeGreaterThan, 1)
+		So(last.Done, ShouldBeTrue)
+		So(last.Transferred, ShouldEqual, len(payload))
+		So(last.Total, ShouldEqual, len(payload))
+		So(last.Rate, ShouldBeGreaterThan, 0)
+	})
+}
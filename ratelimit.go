@@ -0,0 +1,287 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request is not sent because of a rate
+// limit, either failing fast or because waiting for it would exceed the
+// context deadline
+var ErrRateLimited = errors.New("req: rate limited")
+
+// maxIdleKeys is the number of per key states kept by rate limiters and
+// circuit breakers before idle ones are dropped
+const maxIdleKeys = 1024
+
+// RateLimitPolicy describes how outgoing requests are throttled
+type RateLimitPolicy struct {
+	// Rate is the number of requests per second allowed for each key, 0
+	// leaving only the limits advertised by the servers
+	Rate float64
+	// Burst is the number of requests that may be sent at once, it
+	// defaults to Rate rounded up
+	Burst int
+	// Key maps a request to its bucket, the request host by default
+	Key func(req *http.Request) string
+	// FailFast returns ErrRateLimited instead of waiting for a token
+	FailFast bool
+	// IgnoreServerLimits stops following the RateLimit-Limit,
+	// RateLimit-Remaining and RateLimit-Reset headers, their X-RateLimit-*
+	// equivalents and the Retry-After of 429 and 503 responses. A Limit
+	// below Burst shrinks the bucket to it
+	IgnoreServerLimits bool
+}
+
+// RateLimiter throttles requests with a token bucket per key, further
+// holding them back once a server reports its quota is exhausted. It can
+// be shared by several clients
+type RateLimiter struct {
+	policy RateLimitPolicy
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	// burst is the capacity of the bucket, Burst unless the server
+	// advertises a lower limit
+	burst float64
+	last  time.Time
+	// remaining is the server quota left until reset, -1 when unknown
+	remaining int
+	reset     time.Time
+}
+
+// NewRateLimiter create a rate limiter
+func NewRateLimiter(policy RateLimitPolicy) *RateLimiter {
+	if policy.Burst <= 0 {
+		policy.Burst = int(math.Ceil(policy.Rate))
+		if policy.Burst < 1 {
+			policy.Burst = 1
+		}
+	}
+	return &RateLimiter{
+		policy:  policy,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// SetRateLimiter throttles requests through l
+func SetRateLimiter(l *RateLimiter) Option {
+	return func(o *options) {
+		o.rateLimiter = l
+	}
+}
+
+// SetRateLimit throttles requests according to the policy, with a rate
+// limiter of its own
+func SetRateLimit(policy RateLimitPolicy) Option {
+	return SetRateLimiter(NewRateLimiter(policy))
+}
+
+// Wait blocks until a request with the key may be sent
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	d, cancel, err := l.reserve(ctx, key, time.Now())
+	if err != nil || d <= 0 {
+		return err
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+func (l *RateLimiter) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		key := l.key(req)
+		if err := l.Wait(ctx, key); err != nil {
+			return nil, err
+		}
+
+		resp, err := next(ctx, req)
+		if err == nil && !l.policy.IgnoreServerLimits {
+			l.observe(key, resp.Response(), time.Now())
+		}
+		return resp, err
+	}
+}
+
+func (l *RateLimiter) key(req *http.Request) string {
+	if l.policy.Key != nil {
+		return l.policy.Key(req)
+	}
+	return req.URL.Host
+}
+
+// reserve takes a token from the bucket of key, returning how long to
+// wait before using it and a func giving it back
+func (l *RateLimiter) reserve(ctx context.Context, key string, now time.Time) (time.Duration, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	rate := l.policy.Rate
+
+	var wait time.Duration
+	if rate > 0 && b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	quota := false
+	if b.remaining >= 0 {
+		switch {
+		case !now.Before(b.reset):
+			b.remaining = -1
+		case b.remaining == 0:
+			if d := b.reset.Sub(now); d > wait {
+				wait = d
+			}
+		default:
+			quota = true
+		}
+	}
+
+	if wait > 0 {
+		if l.policy.FailFast {
+			return 0, nil, fmt.Errorf("%w: %s for %v", ErrRateLimited, key, wait)
+		}
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(wait)) {
+			return 0, nil, fmt.Errorf("%w: %s for %v, beyond the context deadline", ErrRateLimited, key, wait)
+		}
+	}
+
+	if rate > 0 {
+		b.tokens--
+	}
+	if quota {
+		b.remaining--
+	}
+	reset := b.reset
+	return wait, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if rate > 0 {
+			b.tokens++
+		}
+		if quota && b.reset.Equal(reset) {
+			b.remaining++
+		}
+	}, nil
+}
+
+// bucket returns the bucket of key refilled up to now
+func (l *RateLimiter) bucket(key string, now time.Time) *rateBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleKeys {
+			l.evict(now)
+		}
+		burst := float64(l.policy.Burst)
+		b = &rateBucket{tokens: burst, burst: burst, last: now, remaining: -1}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*l.policy.Rate)
+		b.last = now
+	}
+	return b
+}
+
+// evict drops the buckets that are back to their initial state
+func (l *RateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		full := l.policy.Rate <= 0 ||
+			b.tokens+now.Sub(b.last).Seconds()*l.policy.Rate >= b.burst
+		if full && (b.remaining < 0 || !now.Before(b.reset)) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// observe records the quota a server advertises in res
+func (l *RateLimiter) observe(key string, res *http.Response, now time.Time) {
+	if res == nil {
+		return
+	}
+
+	limit, hasLimit := parseRateLimitLimit(res.Header)
+	remaining, reset, ok := parseRateLimit(res.Header, now)
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		if d, found := parseRetryAfter(res.Header.Get(HeaderRetryAfter)); found {
+			remaining, reset, ok = 0, now.Add(d), true
+		}
+	}
+	if !hasLimit && (!ok || !now.Before(reset)) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(key, now)
+	if hasLimit {
+		b.burst = math.Min(float64(l.policy.Burst), float64(limit))
+		b.tokens = math.Min(b.tokens, b.burst)
+	}
+	if !ok || !now.Before(reset) {
+		return
+	}
+	// requests sent since res was may already have used the quota left
+	if d := b.reset.Sub(reset); b.remaining >= 0 && b.remaining < remaining && d > -time.Second && d < time.Second {
+		return
+	}
+	b.remaining = remaining
+	b.reset = reset
+}
+
+// parseRateLimitLimit parses the RateLimit-Limit header or its
+// X-RateLimit-Limit equivalent, keeping the quota of a value such as
+// "100, 100;w=60"
+func parseRateLimitLimit(header http.Header) (int, bool) {
+	for _, name := range []string{"RateLimit-Limit", "X-RateLimit-Limit"} {
+		v := header.Get(name)
+		if i := strings.IndexAny(v, ",;"); i >= 0 {
+			v = v[:i]
+		}
+		if limit, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && limit > 0 {
+			return limit, true
+		}
+	}
+	return 0, false
+}
+
+// parseRateLimit parses the RateLimit-Remaining and RateLimit-Reset headers
+// or their X-RateLimit-* equivalents, a reset being either a number of
+// seconds or a unix time
+func parseRateLimit(header http.Header, now time.Time) (int, time.Time, bool) {
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		remaining, err := strconv.Atoi(header.Get(prefix + "Remaining"))
+		if err != nil || remaining < 0 {
+			continue
+		}
+		secs, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64)
+		if err != nil || secs < 0 {
+			continue
+		}
+
+		if secs > now.Unix()/2 {
+			return remaining, time.Unix(secs, 0), true
+		}
+		return remaining, now.Add(time.Duration(secs) * time.Second), true
+	}
+	return 0, time.Time{}, false
+}
@@ -0,0 +1,179 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimit(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/quota":
+			w.Header().Set("RateLimit-Limit", "10")
+			w.Header().Set("RateLimit-Remaining", r.URL.Query().Get("remaining"))
+			w.Header().Set("RateLimit-Reset", "1")
+		case "/limited":
+			w.Header().Set("RateLimit-Limit", "2, 2;w=60")
+		case "/github":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		case "/throttled":
+			w.Header().Set(HeaderRetryAfter, "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	Convey("Blocks until a token is available", t, func() {
+		r := New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{Rate: 20, Burst: 1}))
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			_, err := r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+		}
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 90*time.Millisecond)
+	})
+
+	Convey("Fails fast", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{Rate: 1, Burst: 2, FailFast: true}))
+
+		_, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		_, err = r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		_, err = r.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+		So(atomic.LoadInt32(&hits), ShouldEqual, 2)
+	})
+
+	Convey("Fails when the wait exceeds the context deadline", t, func() {
+		r := New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{Rate: 0.5}))
+		_, err := r.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+
+		tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = r.Get(tctx, "/", nil)
+		So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, 50*time.Millisecond)
+	})
+
+	Convey("Gives the token back when the context is canceled", t, func() {
+		l := NewRateLimiter(RateLimitPolicy{Rate: 10, Burst: 1})
+		So(l.Wait(ctx, "k"), ShouldBeNil)
+
+		cctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(10*time.Millisecond, cancel)
+		So(l.Wait(cctx, "k"), ShouldEqual, context.Canceled)
+
+		start := time.Now()
+		So(l.Wait(ctx, "k"), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+	})
+
+	Convey("Keys buckets per route", t, func() {
+		r := New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{
+			Rate:     1,
+			FailFast: true,
+			Key: func(req *http.Request) string {
+				return req.URL.Host + req.URL.Path
+			},
+		}))
+
+		_, err := r.Get(ctx, "/a", nil)
+		So(err, ShouldBeNil)
+		_, err = r.Get(ctx, "/b", nil)
+		So(err, ShouldBeNil)
+		_, err = r.Get(ctx, "/a", nil)
+		So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+	})
+
+	Convey("Follows server advertised limits", t, func() {
+		policy := RateLimitPolicy{FailFast: true}
+
+		Convey("RateLimit headers", func() {
+			r := New(SetBaseURL(ts.URL), SetRateLimit(policy))
+			_, err := r.Get(ctx, "/quota", map[string][]string{"remaining": {"1"}})
+			So(err, ShouldBeNil)
+			_, err = r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			_, err = r.Get(ctx, "/", nil)
+			So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+
+			// blocking until the quota resets
+			r = New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{}))
+			_, err = r.Get(ctx, "/quota", map[string][]string{"remaining": {"0"}})
+			So(err, ShouldBeNil)
+			start := time.Now()
+			_, err = r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+			So(time.Since(start), ShouldBeGreaterThan, 500*time.Millisecond)
+		})
+
+		Convey("RateLimit-Limit below the burst", func() {
+			r := New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{Rate: 0.1, Burst: 5, FailFast: true}))
+			_, err := r.Get(ctx, "/limited", nil)
+			So(err, ShouldBeNil)
+			for i := 0; i < 2; i++ {
+				_, err = r.Get(ctx, "/", nil)
+				So(err, ShouldBeNil)
+			}
+			_, err = r.Get(ctx, "/", nil)
+			So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+		})
+
+		Convey("X-RateLimit headers with a unix reset", func() {
+			r := New(SetBaseURL(ts.URL), SetRateLimit(policy))
+			_, err := r.Get(ctx, "/github", nil)
+			So(err, ShouldBeNil)
+			_, err = r.Get(ctx, "/", nil)
+			So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+		})
+
+		Convey("Retry-After of a 429", func() {
+			r := New(SetBaseURL(ts.URL), SetRateLimit(policy), SetRetry(RetryPolicy{MaxAttempts: 3}))
+			resp, err := r.Get(ctx, "/throttled", nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, http.StatusTooManyRequests)
+
+			atomic.StoreInt32(&hits, 0)
+			_, err = r.Get(ctx, "/", nil)
+			So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+			So(atomic.LoadInt32(&hits), ShouldEqual, 0)
+		})
+
+		Convey("Unless ignored", func() {
+			r := New(SetBaseURL(ts.URL), SetRateLimit(RateLimitPolicy{FailFast: true, IgnoreServerLimits: true}))
+			_, err := r.Get(ctx, "/github", nil)
+			So(err, ShouldBeNil)
+			_, err = r.Get(ctx, "/", nil)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Shared between clients", t, func() {
+		l := NewRateLimiter(RateLimitPolicy{Rate: 1, FailFast: true})
+		a := New(SetBaseURL(ts.URL), SetRateLimiter(l))
+		b := a.With(SetBaseHeader("X-Client", "b"))
+
+		_, err := a.Get(ctx, "/", nil)
+		So(err, ShouldBeNil)
+		_, err = b.Get(ctx, "/", nil)
+		So(errors.Is(err, ErrRateLimited), ShouldBeTrue)
+	})
+}
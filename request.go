@@ -3,8 +3,6 @@ package req
 import (
 	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -18,33 +16,35 @@ type Requester interface {
 	Head(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error)
 	Get(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error)
 	Delete(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error)
+	DeleteJSON(ctx context.Context, urlStr string, body interface{}, opts ...RequestOption) (Responser, error)
 	Patch(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error)
+	PatchBody(ctx context.Context, urlStr string, body io.Reader, opts ...RequestOption) (Responser, error)
+	PatchJSON(ctx context.Context, urlStr string, body interface{}, opts ...RequestOption) (Responser, error)
+	PatchForm(ctx context.Context, urlStr string, body url.Values, opts ...RequestOption) (Responser, error)
+	Options(ctx context.Context, urlStr string, opts ...RequestOption) (Responser, error)
+	Capabilities(ctx context.Context, urlStr string, opts ...RequestOption) (*Capabilities, error)
+	Trace(ctx context.Context, urlStr string, opts ...RequestOption) (Responser, error)
 	Post(ctx context.Context, urlStr string, body io.Reader, opts ...RequestOption) (Responser, error)
 	PostJSON(ctx context.Context, urlStr string, body interface{}, opts ...RequestOption) (Responser, error)
 	PostForm(ctx context.Context, urlStr string, body url.Values, opts ...RequestOption) (Responser, error)
 	Put(ctx context.Context, urlStr string, body io.Reader, opts ...RequestOption) (Responser, error)
 	PutJSON(ctx context.Context, urlStr string, body interface{}, opts ...RequestOption) (Responser, error)
 	PutForm(ctx context.Context, urlStr string, body url.Values, opts ...RequestOption) (Responser, error)
+	PostMultipart(ctx context.Context, urlStr string, body *Multipart, opts ...RequestOption) (Responser, error)
+	PutMultipart(ctx context.Context, urlStr string, body *Multipart, opts ...RequestOption) (Responser, error)
+	Download(ctx context.Context, urlStr, path string, opts ...DownloadOption) (int64, error)
+	DownloadTo(ctx context.Context, urlStr string, w io.WriterAt, opts ...DownloadOption) (int64, error)
+	Events(ctx context.Context, urlStr string, handler func(Event) error, opts ...RequestOption) error
 	Do(ctx context.Context, urlStr, method string, body io.Reader, opts ...RequestOption) (Responser, error)
+	With(opt ...Option) Requester
 }
 
-// RequestURL get request url
-func RequestURL(base, router string) string {
-	var buf bytes.Buffer
-	if l := len(base); l > 0 {
-		if base[l-1] == '/' {
-			base = base[:l-1]
-		}
-		buf.WriteString(base)
+var _ EncodedRequester = &request{}
 
-		if rl := len(router); rl > 0 {
-			if router[0] != '/' {
-				buf.WriteByte('/')
-			}
-		}
-	}
-	buf.WriteString(router)
-	return buf.String()
+// EncodedRequester is implemented by the requesters encoding request bodies
+// with the codecs registered on them, as the ones created by New
+type EncodedRequester interface {
+	DoEncoded(ctx context.Context, urlStr, method, contentType string, body interface{}, opts ...RequestOption) (Responser, error)
 }
 
 // New create a request instance
@@ -53,37 +53,79 @@ func New(opt ...Option) Requester {
 	for _, o := range opt {
 		o(&opts)
 	}
+	return newRequest(opts)
+}
 
+func newRequest(opts options) *request {
 	req := &request{
 		opts: opts,
 		cli: &http.Client{
-			Transport:     opts.transport,
-			CheckRedirect: opts.checkRedirect,
-			Jar:           opts.cookieJar,
-			Timeout:       opts.timeout,
+			Transport: opts.transport,
+			Jar:       opts.cookieJar,
+			Timeout:   opts.timeout,
 		},
 	}
+	req.cli.CheckRedirect = req.checkRedirect
+	req.handler = req.builtin(req.roundTrip)
 
 	return req
 }
 
 type request struct {
-	opts options
-	cli  *http.Client
+	opts    options
+	cli     *http.Client
+	handler Handler
 }
 
-func (r *request) parseQueryParam(urlStr string, param url.Values) string {
-	if param != nil {
-		c := '?'
-		if strings.IndexByte(urlStr, '?') != -1 {
-			c = '&'
-		}
-		urlStr = fmt.Sprintf("%s%c%s", urlStr, c, param.Encode())
+// builtin wraps h with the middlewares enabled through options
+func (r *request) builtin(h Handler) Handler {
+	if l := r.opts.concurrency; l != nil {
+		h = l.middleware(h)
+	}
+	if l := r.opts.rateLimiter; l != nil {
+		h = l.middleware(h)
+	}
+	if b := r.opts.breaker; b != nil {
+		h = b.middleware(h)
+	}
+	if b := r.opts.balancer; b != nil {
+		b.useTransport(r.transport())
+		h = b.middleware(h)
+	}
+	if d := r.opts.discovery; d != nil {
+		d.useTransport(r.transport())
+		h = d.middleware(h)
+	}
+	if p := r.opts.retry; p != nil {
+		h = p.middleware(h)
+	}
+	h = (&hedger{policy: r.opts.hedge}).middleware(h)
+	if store := r.opts.cache; store != nil {
+		h = newHTTPCache(store, r.opts.codecs).middleware(h)
+	}
+	return h
+}
+
+// transport returns the transport the client sends requests through
+func (r *request) transport() http.RoundTripper {
+	if r.cli.Transport != nil {
+		return r.cli.Transport
+	}
+	return http.DefaultTransport
+}
+
+// With derives a client from r overriding the given options. The derived
+// client shares the transport, and so the connection pool, and the cookie
+// jar of r unless they are overridden too
+func (r *request) With(opt ...Option) Requester {
+	opts := r.opts.clone()
+	for _, o := range opt {
+		o(&opts)
 	}
-	return urlStr
+	return newRequest(opts)
 }
 
-func (r *request) fillRequest(req *http.Request, opts ...RequestOption) (*http.Request, error) {
+func (r *request) fillRequest(req *http.Request, urlStr string, opts ...RequestOption) (*http.Request, *requestOptions, error) {
 	if req.Header == nil {
 		req.Header = make(http.Header)
 	}
@@ -94,16 +136,37 @@ func (r *request) fillRequest(req *http.Request, opts ...RequestOption) (*http.R
 
 	ro := &requestOptions{
 		request: req,
+		baseURL: r.opts.baseURL,
 	}
 	for _, opt := range opts {
 		opt(ro)
 	}
+	if ro.err != nil {
+		return nil, nil, ro.err
+	}
+
+	if ro.pathParams != nil {
+		var err error
+		if urlStr, err = ExpandTemplate(urlStr, ro.pathParams); err != nil {
+			return nil, nil, err
+		}
+	}
+	u, err := resolveURL(ro.baseURL, urlStr, ro.query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(r.opts.query) > 0 && !isAbsoluteURL(urlStr) {
+		u.RawQuery = mergeQuery(r.opts.query.Encode(), u.RawQuery)
+	}
+	req.URL = u
+	req.Host = u.Host
 
 	if fn := ro.handle; fn != nil {
-		return fn(req)
+		req, err := fn(req)
+		return req, ro, err
 	}
 
-	return req, nil
+	return req, ro, nil
 }
 
 func (r *request) doForm(ctx context.Context, urlStr, method string, body url.Values, opts ...RequestOption) (Responser, error) {
@@ -122,38 +185,87 @@ func (r *request) doForm(ctx context.Context, urlStr, method string, body url.Va
 }
 
 func (r *request) doJSON(ctx context.Context, urlStr, method string, body interface{}, opts ...RequestOption) (Responser, error) {
-	buf := new(bytes.Buffer)
-	err := json.NewEncoder(buf).Encode(body)
+	return r.DoEncoded(ctx, urlStr, method, jsonContentType(body), body, opts...)
+}
+
+// doEncoded sends body encoded for contentType through r, with the
+// built-in codecs unless r encodes bodies itself
+func doEncoded(ctx context.Context, r Requester, urlStr, method, contentType string, body interface{}, opts ...RequestOption) (Responser, error) {
+	if e, ok := r.(EncodedRequester); ok {
+		return e.DoEncoded(ctx, urlStr, method, contentType, body, opts...)
+	}
+	buf, err := encodeBody(nil, contentType, body)
 	if err != nil {
 		return nil, err
 	}
+	return r.Do(ctx, urlStr, method, bytes.NewReader(buf), append([]RequestOption{SetContentType(contentType)}, opts...)...)
+}
 
-	var ro []RequestOption
-	ro = append(ro, SetContentType(MIMEApplicationJSONCharsetUTF8))
-	if len(opts) > 0 {
-		ro = append(ro, opts...)
+func encodeBody(codecs map[string]Codec, contentType string, body interface{}) ([]byte, error) {
+	codec, err := lookupCodec(codecs, contentType)
+	if err != nil {
+		return nil, err
 	}
-	return r.Do(ctx, urlStr, method, buf, ro...)
+	return codec.Marshal(body)
 }
 
 func (r *request) httpDo(ctx context.Context, req *http.Request, f func(*http.Response, error) error) error {
 	return f(r.cli.Do(req))
 }
 
+func (r *request) roundTrip(ctx context.Context, req *http.Request) (Responser, error) {
+	if ctx != req.Context() {
+		req = req.WithContext(ctx)
+	}
+	if u, ok := ctx.Value(uploadKey{}).(*uploadProgress); ok {
+		req = u.track(req)
+	}
+
+	var resp Responser
+	err := r.httpDo(ctx, req, func(res *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		resp = &response{resp: res, codecs: r.opts.codecs}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 func (r *request) Head(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error) {
-	return r.Do(ctx, r.parseQueryParam(urlStr, queryParam), http.MethodHead, nil, opts...)
+	return r.Do(ctx, urlStr, http.MethodHead, nil, append([]RequestOption{setQuery(queryParam)}, opts...)...)
 }
 
 func (r *request) Get(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error) {
-	return r.Do(ctx, r.parseQueryParam(urlStr, queryParam), http.MethodGet, nil, opts...)
+	return r.Do(ctx, urlStr, http.MethodGet, nil, append([]RequestOption{setQuery(queryParam)}, opts...)...)
 }
 
 func (r *request) Delete(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error) {
-	return r.Do(ctx, r.parseQueryParam(urlStr, queryParam), http.MethodDelete, nil, opts...)
+	return r.Do(ctx, urlStr, http.MethodDelete, nil, append([]RequestOption{setQuery(queryParam)}, opts...)...)
 }
 
 func (r *request) Patch(ctx context.Context, urlStr string, queryParam url.Values, opts ...RequestOption) (Responser, error) {
-	return r.Do(ctx, r.parseQueryParam(urlStr, queryParam), http.MethodPatch, nil, opts...)
+	return r.Do(ctx, urlStr, http.MethodPatch, nil, append([]RequestOption{setQuery(queryParam)}, opts...)...)
+}
+
+func (r *request) DeleteJSON(ctx context.Context, urlStr string, body interface{}, opts ...RequestOption) (Responser, error) {
+	return r.doJSON(ctx, urlStr, http.MethodDelete, body, opts...)
+}
+
+func (r *request) PatchBody(ctx context.Context, urlStr string, body io.Reader, opts ...RequestOption) (Responser, error) {
+	return r.Do(ctx, urlStr, http.MethodPatch, body, opts...)
+}
+
+func (r *request) PatchJSON(ctx context.Context, urlStr string, body interface{}, opts ...RequestOption) (Responser, error) {
+	return r.doJSON(ctx, urlStr, http.MethodPatch, body, opts...)
+}
+
+func (r *request) PatchForm(ctx context.Context, urlStr string, body url.Values, opts ...RequestOption) (Responser, error) {
+	return r.doForm(ctx, urlStr, http.MethodPatch, body, opts...)
 }
 
 func (r *request) Post(ctx context.Context, urlStr string, body io.Reader, opts ...RequestOption) (Responser, error) {
@@ -180,33 +292,92 @@ func (r *request) PutForm(ctx context.Context, urlStr string, body url.Values, o
 	return r.doForm(ctx, urlStr, http.MethodPut, body, opts...)
 }
 
+func (r *request) PostMultipart(ctx context.Context, urlStr string, body *Multipart, opts ...RequestOption) (Responser, error) {
+	return r.doMultipart(ctx, urlStr, http.MethodPost, body, opts...)
+}
+
+func (r *request) PutMultipart(ctx context.Context, urlStr string, body *Multipart, opts ...RequestOption) (Responser, error) {
+	return r.doMultipart(ctx, urlStr, http.MethodPut, body, opts...)
+}
+
+func (r *request) DoEncoded(ctx context.Context, urlStr, method, contentType string, body interface{}, opts ...RequestOption) (Responser, error) {
+	buf, err := encodeBody(r.opts.codecs, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ro []RequestOption
+	ro = append(ro, SetContentType(contentType))
+	if len(opts) > 0 {
+		ro = append(ro, opts...)
+	}
+	return r.Do(ctx, urlStr, method, bytes.NewReader(buf), ro...)
+}
+
 func (r *request) Do(ctx context.Context, urlStr, method string, body io.Reader, opts ...RequestOption) (Responser, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
-	url := RequestURL(r.opts.baseURL, urlStr)
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, "", body)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err = r.fillRequest(req, opts...)
+	req, ro, err := r.fillRequest(req, urlStr, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	var resp Responser
-	err = r.httpDo(ctx, req, func(res *http.Response, err error) error {
-		if err != nil {
-			return err
-		}
-		resp = newResponse(res)
-		return nil
-	})
+	ctx = req.Context()
+	cancel := context.CancelFunc(func() {})
+	if ro.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, ro.timeout)
+	}
+	if ro.checkRedirect != nil {
+		ctx = context.WithValue(ctx, redirectKey{}, ro.checkRedirect)
+	}
+	if ro.hedge != nil {
+		ctx = context.WithValue(ctx, hedgeKey{}, ro.hedge)
+	}
+	ctx = ro.trackUpload(ctx)
+	if ctx != req.Context() {
+		req = req.WithContext(ctx)
+	}
+
+	h := chain(r.handler, r.opts.middlewares, ro.middlewares)
+	resp, err := h(ctx, req)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
+	res, err := httpResponse(resp)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := ro.limitResponse(res); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	check := r.opts.statusError
+	if ro.statusError != nil {
+		check = *ro.statusError
+	}
+	if check {
+		if err := checkStatus(res); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	ro.trackDownload(res)
+
+	if res.Body == nil {
+		cancel()
+	} else {
+		res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	}
 	return resp, nil
 }
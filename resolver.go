@@ -0,0 +1,247 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFileResolverInterval is how often a FileResolver checks its file
+// for changes by default
+const DefaultFileResolverInterval = time.Second
+
+// UnknownServiceError is returned by resolvers not knowing a service
+type UnknownServiceError struct {
+	Service string
+}
+
+func (e *UnknownServiceError) Error() string {
+	return "req: unknown service " + strconv.Quote(e.Service)
+}
+
+// StaticResolver resolves services from a fixed list of endpoints
+type StaticResolver map[string][]Endpoint
+
+// Resolve implements Resolver
+func (r StaticResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	endpoints, ok := r[service]
+	if !ok {
+		return nil, &UnknownServiceError{Service: service}
+	}
+	return append([]Endpoint(nil), endpoints...), nil
+}
+
+// SRVResolver resolves services through DNS SRV records, looking up
+// _Service._Proto.<service>.Domain. Only the records of the lowest
+// priority are used, their weight becoming the endpoint weight
+type SRVResolver struct {
+	// Service and Proto, such as "http" and "tcp", prefix the looked up
+	// name, which is used as is when both are empty
+	Service string
+	Proto   string
+	// Domain, when set, is appended to the service name
+	Domain string
+	// Scheme is the scheme of the endpoints, "http" by default
+	Scheme string
+	// Resolver looks the records up, net.DefaultResolver if nil
+	Resolver *net.Resolver
+}
+
+// Resolve implements Resolver
+func (r *SRVResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	name := service
+	if r.Domain != "" {
+		name += "." + strings.TrimPrefix(r.Domain, ".")
+	}
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, name)
+	if len(records) == 0 {
+		if err == nil {
+			err = &UnknownServiceError{Service: service}
+		}
+		return nil, err
+	}
+
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	endpoints := make([]Endpoint, 0, len(records))
+	for _, srv := range records {
+		if srv.Priority != records[0].Priority {
+			break
+		}
+		host := strings.TrimSuffix(srv.Target, ".")
+		endpoints = append(endpoints, Endpoint{
+			URL:    scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+			Weight: int(srv.Weight),
+		})
+	}
+	return endpoints, nil
+}
+
+// FileResolver resolves services from a JSON or YAML file, YAML being
+// used for the .yaml and .yml extensions, that maps service names to
+// their endpoints:
+//
+//	billing:
+//	  - url: http://10.0.0.1:8080
+//	    weight: 2
+//	  - url: http://10.0.0.2:8080
+//
+// The file is reloaded when it changes, the previous endpoints being kept
+// while it cannot be read or parsed
+type FileResolver struct {
+	path string
+
+	mu       sync.Mutex
+	services map[string][]Endpoint
+	modTime  time.Time
+	size     int64
+	watchers []func(service string)
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewFileResolver create a resolver loading path, checking it for changes
+// every interval, DefaultFileResolverInterval if not positive
+func NewFileResolver(path string, interval time.Duration) (*FileResolver, error) {
+	if interval <= 0 {
+		interval = DefaultFileResolverInterval
+	}
+
+	r := &FileResolver{
+		path: path,
+		done: make(chan struct{}),
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	r.wg.Add(1)
+	go r.watch(interval)
+	return r, nil
+}
+
+// Resolve implements Resolver
+func (r *FileResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	endpoints, ok := r.services[service]
+	if !ok {
+		return nil, &UnknownServiceError{Service: service}
+	}
+	return append([]Endpoint(nil), endpoints...), nil
+}
+
+// Watch implements Watcher
+func (r *FileResolver) Watch(fn func(service string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers = append(r.watchers, fn)
+}
+
+// Close stops watching the file
+func (r *FileResolver) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+	return nil
+}
+
+func (r *FileResolver) watch(interval time.Duration) {
+	defer r.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-r.done:
+			return
+		}
+
+		changed, err := r.reload()
+		if err != nil || len(changed) == 0 {
+			continue
+		}
+
+		r.mu.Lock()
+		watchers := append([]func(service string){}, r.watchers...)
+		r.mu.Unlock()
+		for _, service := range changed {
+			for _, fn := range watchers {
+				fn(service)
+			}
+		}
+	}
+}
+
+// reload loads the file when it changed, returning the services whose
+// endpoints changed
+func (r *FileResolver) reload() ([]string, error) {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	unchanged := r.services != nil && fi.ModTime().Equal(r.modTime) && fi.Size() == r.size
+	r.mu.Unlock()
+	if unchanged {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	services := make(map[string][]Endpoint)
+	switch strings.ToLower(filepath.Ext(r.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &services)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&services)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("req: parsing %s: %w", r.path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changed []string
+	for name, endpoints := range services {
+		if !reflect.DeepEqual(r.services[name], endpoints) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range r.services {
+		if _, ok := services[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	r.services = services
+	r.modTime = fi.ModTime()
+	r.size = fi.Size()
+	return changed, nil
+}
@@ -18,12 +18,54 @@ type Responser interface {
 	Close()
 }
 
+// Decode decodes the body of resp into v with the codec matching the
+// response Content-Type, JSON being assumed when it is missing. The codecs
+// registered on the client are used when resp comes from one created by
+// New, the built-in ones otherwise. An empty body leaves v untouched
+func Decode(resp Responser, v interface{}) error {
+	if d, ok := resp.(interface{ Decode(v interface{}) error }); ok {
+		return d.Decode(v)
+	}
+	return decodeBody(resp.Response(), nil, v)
+}
+
+// NewJSONStream returns an iterator over the JSON values of the body of
+// resp
+func NewJSONStream(resp Responser) *JSONStream {
+	res := resp.Response()
+	return newJSONStream(res.Body, res.Header.Get(HeaderContentType))
+}
+
+// FromCache reports whether resp was served by the HTTP cache, including
+// responses revalidated with a 304
+func FromCache(resp Responser) bool {
+	c, ok := resp.(interface{ FromCache() bool })
+	return ok && c.FromCache()
+}
+
+// Attempt returns which copy of a hedged request produced resp, 1 being
+// the original request
+func Attempt(resp Responser) int {
+	if a, ok := resp.(interface{ Attempt() int }); ok {
+		return a.Attempt()
+	}
+	return 1
+}
+
+// NewResponse wraps an *http.Response as a Responser, which allows a
+// Middleware to short-circuit a request with a synthetic response
+func NewResponse(resp *http.Response) Responser {
+	return newResponse(resp)
+}
+
 func newResponse(resp *http.Response) *response {
-	return &response{resp}
+	return &response{resp: resp}
 }
 
 type response struct {
-	resp *http.Response
+	resp      *http.Response
+	codecs    map[string]Codec
+	fromCache bool
 }
 
 func (r *response) StatusCode() int {
@@ -58,6 +100,47 @@ func (r *response) JSON(v interface{}) error {
 	return json.NewDecoder(r.resp.Body).Decode(v)
 }
 
+// Decode decodes the body into v, see the Decode function
+func (r *response) Decode(v interface{}) error {
+	return decodeBody(r.resp, r.codecs, v)
+}
+
+// JSONStream returns an iterator over the JSON values of the body
+func (r *response) JSONStream() *JSONStream {
+	return NewJSONStream(r)
+}
+
+// FromCache reports whether the response was served by the HTTP cache
+func (r *response) FromCache() bool {
+	return r.fromCache
+}
+
+func decodeBody(res *http.Response, codecs map[string]Codec, v interface{}) error {
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNoContent || res.ContentLength == 0 {
+		return nil
+	}
+
+	contentType := res.Header.Get(HeaderContentType)
+	if contentType == "" {
+		contentType = MIMEApplicationJSON
+	}
+	codec, err := lookupCodec(codecs, contentType)
+	if err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	return codec.Unmarshal(buf, v)
+}
+
 func (r *response) Close() {
 	if !r.resp.Close {
 		r.resp.Body.Close()
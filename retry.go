@@ -0,0 +1,262 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default retry settings
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBaseDelay   = 100 * time.Millisecond
+	DefaultRetryMaxDelay    = 10 * time.Second
+)
+
+// DefaultRetryStatusCodes are the response statuses retried when
+// RetryPolicy.StatusCodes is empty
+var DefaultRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy describes when and how a failed request is retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt, it doubles
+	// after every attempt and a full jitter is applied to it
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. A Retry-After longer than MaxDelay
+	// stops retrying and returns the last response
+	MaxDelay time.Duration
+	// AttemptTimeout limits the duration of each attempt, 0 means no limit
+	AttemptTimeout time.Duration
+	// StatusCodes lists the retryable response statuses
+	StatusCodes []int
+	// Retryable, when set, decides whether an attempt is retried instead of
+	// StatusCodes and the default error classification
+	Retryable func(resp *http.Response, err error) bool
+	// AllowNonIdempotent allows retrying methods such as POST and PATCH
+	// that do not carry an Idempotency-Key header
+	AllowNonIdempotent bool
+}
+
+// SetRetry retries failed requests according to the policy
+func SetRetry(policy RetryPolicy) Option {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultRetryBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryMaxDelay
+	}
+	if len(policy.StatusCodes) == 0 {
+		policy.StatusCodes = DefaultRetryStatusCodes
+	}
+	return func(o *options) {
+		o.retry = &policy
+	}
+}
+
+func (p *RetryPolicy) middleware(next Handler) Handler {
+	return func(ctx context.Context, req *http.Request) (Responser, error) {
+		if p.MaxAttempts < 2 || !p.allowed(req) {
+			return next(ctx, req)
+		}
+		if err := rewindableBody(req); err != nil {
+			return nil, err
+		}
+
+		for attempt := 1; ; attempt++ {
+			actx, cancel := ctx, context.CancelFunc(func() {})
+			if p.AttemptTimeout > 0 {
+				actx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+			}
+
+			areq := req.Clone(actx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					cancel()
+					return nil, err
+				}
+				areq.Body = body
+			}
+
+			resp, err := next(actx, areq)
+			var res *http.Response
+			if resp != nil {
+				res = resp.Response()
+			}
+
+			if attempt < p.MaxAttempts && ctx.Err() == nil && p.retryable(res, err) {
+				if delay, ok := p.backoff(ctx, attempt, res); ok {
+					if res != nil {
+						drainBody(res.Body)
+					}
+					cancel()
+
+					t := time.NewTimer(delay)
+					select {
+					case <-ctx.Done():
+						t.Stop()
+						return nil, ctx.Err()
+					case <-t.C:
+					}
+					continue
+				}
+			}
+
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			if res == nil || res.Body == nil {
+				cancel()
+				return resp, nil
+			}
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+			return resp, nil
+		}
+	}
+}
+
+func (p *RetryPolicy) allowed(req *http.Request) bool {
+	if p.AllowNonIdempotent {
+		return true
+	}
+	return isIdempotent(req)
+}
+
+func (p *RetryPolicy) retryable(res *http.Response, err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(res, err)
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) &&
+			!errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrCircuitOpen) &&
+			!errors.Is(err, ErrConcurrencyLimited)
+	}
+	if res == nil {
+		return false
+	}
+	for _, code := range p.StatusCodes {
+		if res.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the next attempt, or false when the
+// delay would exceed MaxDelay or the context deadline
+func (p *RetryPolicy) backoff(ctx context.Context, attempt int, res *http.Response) (time.Duration, bool) {
+	ceil := p.BaseDelay << uint(attempt-1)
+	if ceil <= 0 || ceil > p.MaxDelay {
+		ceil = p.MaxDelay
+	}
+	delay := time.Duration(rand.Int63n(int64(ceil) + 1))
+
+	if res != nil {
+		if d, ok := parseRetryAfter(res.Header.Get(HeaderRetryAfter)); ok {
+			if d > p.MaxDelay {
+				return 0, false
+			}
+			delay = d
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return 0, false
+	}
+	return delay, true
+}
+
+// parseRetryAfter parses a Retry-After value given either in seconds or
+// as an HTTP date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// rewindableBody makes sure the request body can be read again through
+// GetBody, buffering it in memory when the original reader cannot
+func rewindableBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = int64(len(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// drainBody discards a bounded amount of the body so that the underlying
+// connection can be reused, and closes it
+func drainBody(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, body, 4<<10)
+	body.Close()
+}
+
+// cancelBody releases a context when the body it belongs to is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
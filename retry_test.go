@@ -0,0 +1,107 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetry(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/slow":
+			if n == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+		case "/after":
+			if n == 1 {
+				w.Header().Set(HeaderRetryAfter, "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		default:
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		}
+		fmt.Fprintf(w, "%d:%s", n, body)
+	}))
+	defer ts.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	Convey("Test Retry Rewinds Body", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetRetry(policy))
+
+		resp, err := r.Put(context.Background(), ts.URL, bytes.NewBufferString("foo"))
+		So(err, ShouldBeNil)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "3:foo")
+	})
+
+	Convey("Test Retry Non Idempotent", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetRetry(policy))
+
+		resp, err := r.PostJSON(context.Background(), ts.URL, "foo")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusBadGateway)
+		resp.Close()
+
+		atomic.StoreInt32(&hits, 0)
+		resp, err = r.PostJSON(context.Background(), ts.URL, "foo", SetHeader("Idempotency-Key", "k"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+		resp.Close()
+	})
+
+	Convey("Test Retry After", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		r := New(SetRetry(policy))
+
+		start := time.Now()
+		resp, err := r.Get(context.Background(), ts.URL+"/after", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+		resp.Close()
+
+		atomic.StoreInt32(&hits, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		resp, err = r.Get(ctx, ts.URL+"/after", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusServiceUnavailable)
+		resp.Close()
+	})
+
+	Convey("Test Retry Attempt Timeout", t, func() {
+		atomic.StoreInt32(&hits, 0)
+		p := policy
+		p.AttemptTimeout = 50 * time.Millisecond
+		r := New(SetRetry(p))
+
+		resp, err := r.Get(context.Background(), ts.URL+"/slow", nil)
+		So(err, ShouldBeNil)
+
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "2:")
+	})
+}
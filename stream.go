@@ -0,0 +1,159 @@
+package req
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// JSONStream iterates over the values of a JSON response one at a time,
+// either newline-delimited (NDJSON, JSON Lines) or the elements of a
+// top-level array, without reading the whole body into memory. Responses
+// with a newline-delimited content type are always read as such, others
+// being read as an array when they start with '[':
+//
+//	s := NewJSONStream(resp)
+//	defer s.Close()
+//	for s.Next() {
+//		var v Item
+//		if err := s.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//	return s.Err()
+type JSONStream struct {
+	body    io.ReadCloser
+	br      *bufio.Reader
+	dec     *json.Decoder
+	started bool
+	lines   bool
+	array   bool
+	done    bool
+	cur     json.RawMessage
+	err     error
+}
+
+// ndjsonTypes are the media types of newline-delimited JSON
+var ndjsonTypes = map[string]bool{
+	MIMEApplicationNDJSON:      true,
+	"application/ndjson":       true,
+	"application/jsonl":        true,
+	"application/x-jsonlines":  true,
+	"application/json-lines":   true,
+	"application/x-json-lines": true,
+}
+
+func newJSONStream(body io.ReadCloser, contentType string) *JSONStream {
+	br := bufio.NewReader(body)
+	return &JSONStream{
+		body:  body,
+		br:    br,
+		dec:   json.NewDecoder(br),
+		lines: ndjsonTypes[mediaType(contentType)],
+	}
+}
+
+// Next advances to the next value, returning false at the end of the
+// stream or on error, after which the body is closed
+func (s *JSONStream) Next() bool {
+	if s.done {
+		return false
+	}
+
+	if !s.started && !s.lines {
+		s.started = true
+		c, err := s.peek()
+		if err != nil {
+			return s.finish(err)
+		}
+		if c == '[' {
+			s.array = true
+			if _, err := s.dec.Token(); err != nil {
+				return s.finish(err)
+			}
+		}
+	}
+
+	if s.array && !s.dec.More() {
+		if _, err := s.dec.Token(); err != nil {
+			return s.finish(err)
+		}
+		return s.finish(nil)
+	}
+
+	s.cur = nil
+	if err := s.dec.Decode(&s.cur); err != nil {
+		return s.finish(err)
+	}
+	return true
+}
+
+// Decode decodes the current value into v
+func (s *JSONStream) Decode(v interface{}) error {
+	if s.cur == nil {
+		return errors.New("req: JSONStream.Decode called without a successful Next")
+	}
+	return json.Unmarshal(s.cur, v)
+}
+
+// Raw returns the current value undecoded
+func (s *JSONStream) Raw() json.RawMessage {
+	return s.cur
+}
+
+// Err returns the error that stopped the iteration, if any
+func (s *JSONStream) Err() error {
+	return s.err
+}
+
+// Close stops the iteration and closes the response body
+func (s *JSONStream) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.cur = nil
+	return s.body.Close()
+}
+
+// peek returns the first non-whitespace byte without consuming it
+func (s *JSONStream) peek() (byte, error) {
+	for {
+		c, err := s.br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, s.br.UnreadByte()
+	}
+}
+
+func (s *JSONStream) finish(err error) bool {
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	s.Close()
+	return false
+}
+
+// EachJSON decodes the values of a JSON stream response into T and calls
+// fn for each of them, stopping at the first error
+func EachJSON[T any](resp Responser, fn func(T) error) error {
+	s := NewJSONStream(resp)
+	defer s.Close()
+
+	for s.Next() {
+		var v T
+		if err := s.Decode(&v); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,98 @@
+package req
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJSONStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ndjson":
+			w.Header().Set(HeaderContentType, MIMEApplicationNDJSON)
+			fmt.Fprint(w, "{\"n\":1}\n{\"n\":2}\n\n{\"n\":3}\n")
+		case "/ndjson-arrays":
+			w.Header().Set(HeaderContentType, MIMEApplicationNDJSON+"; charset=utf-8")
+			fmt.Fprint(w, "[1,2]\n[3]\n[]\n")
+		case "/array":
+			fmt.Fprint(w, ` [ {"n":1}, {"n":2} , {"n":3} ] `)
+		case "/bad":
+			fmt.Fprint(w, `[{"n":1}, {"n":`)
+		case "/endless":
+			fmt.Fprint(w, "[")
+			for i := 0; r.Context().Err() == nil; i++ {
+				if _, err := fmt.Fprintf(w, `{"n":%d},`, i); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	type item struct {
+		N int `json:"n"`
+	}
+	r := New(SetBaseURL(ts.URL))
+
+	for _, path := range []string{"/ndjson", "/array"} {
+		Convey("Test JSON Stream "+path, t, func() {
+			resp, err := r.Get(context.Background(), path, nil)
+			So(err, ShouldBeNil)
+
+			var got []int
+			err = EachJSON(resp, func(v item) error {
+				got = append(got, v.N)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, []int{1, 2, 3})
+		})
+	}
+
+	Convey("Test JSON Stream NDJSON Of Arrays", t, func() {
+		resp, err := r.Get(context.Background(), "/ndjson-arrays", nil)
+		So(err, ShouldBeNil)
+
+		var got [][]int
+		err = EachJSON(resp, func(v []int) error {
+			got = append(got, v)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(got, ShouldResemble, [][]int{{1, 2}, {3}, {}})
+	})
+
+	Convey("Test JSON Stream Error", t, func() {
+		resp, err := r.Get(context.Background(), "/bad", nil)
+		So(err, ShouldBeNil)
+
+		s := NewJSONStream(resp)
+		So(s.Next(), ShouldBeTrue)
+		So(string(s.Raw()), ShouldEqual, `{"n":1}`)
+		So(s.Next(), ShouldBeFalse)
+		So(s.Err(), ShouldNotBeNil)
+	})
+
+	Convey("Test JSON Stream Early Termination", t, func() {
+		resp, err := r.Get(context.Background(), "/endless", nil)
+		So(err, ShouldBeNil)
+
+		errStop := errors.New("stop")
+		var n int
+		err = EachJSON(resp, func(v item) error {
+			if n = v.N; n == 100 {
+				return errStop
+			}
+			return nil
+		})
+		So(err, ShouldEqual, errStop)
+		So(n, ShouldEqual, 100)
+	})
+}
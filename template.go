@@ -0,0 +1,170 @@
+package req
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetPathParams expands the request url as an RFC 6570 URI template,
+// levels 1 to 3, with the given variables:
+//
+//	r.Get(ctx, "/repos/{owner}/{repo}/issues{?state,labels}", nil, req.SetPathParams(map[string]string{
+//		"owner": "golang",
+//		"repo":  "go",
+//		"state": "open",
+//	}))
+//
+// A variable missing from params is an error, except in the optional
+// "?", "&" and ";" expansions where it is omitted. Without SetPathParams
+// the url is not a template and its braces are sent as is
+func SetPathParams(params map[string]string) RequestOption {
+	return func(o *requestOptions) {
+		if o.pathParams == nil {
+			o.pathParams = make(map[string]string, len(params))
+		}
+		for k, v := range params {
+			o.pathParams[k] = v
+		}
+	}
+}
+
+// templateOp describes the expansion of an RFC 6570 operator
+type templateOp struct {
+	first    string
+	sep      string
+	named    bool
+	ifEmpty  string
+	reserved bool
+	optional bool
+}
+
+var templateOps = map[byte]templateOp{
+	0:   {sep: ","},
+	'+': {sep: ",", reserved: true},
+	'#': {first: "#", sep: ",", reserved: true},
+	'.': {first: ".", sep: "."},
+	'/': {first: "/", sep: "/"},
+	';': {first: ";", sep: ";", named: true, optional: true},
+	'?': {first: "?", sep: "&", named: true, ifEmpty: "=", optional: true},
+	'&': {first: "&", sep: "&", named: true, ifEmpty: "=", optional: true},
+}
+
+// ExpandTemplate expands an RFC 6570 URI template, see SetPathParams
+func ExpandTemplate(template string, params map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(template, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(template[i:], '}')
+		if j < 0 {
+			return "", fmt.Errorf("req: unclosed expression in URI template %q", template)
+		}
+
+		b.WriteString(template[:i])
+		if err := expandExpression(&b, template[i+1:i+j], params); err != nil {
+			return "", err
+		}
+		template = template[i+j+1:]
+	}
+	b.WriteString(template)
+	return b.String(), nil
+}
+
+func expandExpression(b *strings.Builder, expr string, params map[string]string) error {
+	if expr == "" {
+		return fmt.Errorf("req: empty expression in URI template")
+	}
+
+	opc := byte(0)
+	if _, ok := templateOps[expr[0]]; ok {
+		opc, expr = expr[0], expr[1:]
+	}
+	op := templateOps[opc]
+
+	first := true
+	for _, name := range strings.Split(expr, ",") {
+		if !validVarName(name) {
+			return fmt.Errorf("req: invalid variable %q in URI template", name)
+		}
+
+		v, ok := params[name]
+		if !ok {
+			if op.optional {
+				continue
+			}
+			return fmt.Errorf("req: URI template variable %q is not set", name)
+		}
+
+		if first {
+			b.WriteString(op.first)
+			first = false
+		} else {
+			b.WriteString(op.sep)
+		}
+
+		if op.named {
+			b.WriteString(name)
+			if v == "" {
+				b.WriteString(op.ifEmpty)
+				continue
+			}
+			b.WriteByte('=')
+		}
+		b.WriteString(templateEscape(v, op.reserved))
+	}
+	return nil
+}
+
+func validVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '.':
+		case c == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// templateEscape percent-encodes every byte outside the unreserved set,
+// and outside the reserved set too unless reserved is true, in which case
+// existing percent-encoded triplets are kept
+func templateEscape(s string, reserved bool) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isUnreserved(c):
+			b.WriteByte(c)
+		case reserved && strings.IndexByte(":/?#[]@!$&'()*+,;=", c) >= 0:
+			b.WriteByte(c)
+		case reserved && c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteString(s[i : i+3])
+			i += 2
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,102 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	// RFC 6570 section 1.2 examples
+	vars := map[string]string{
+		"var":   "value",
+		"hello": "Hello World!",
+		"empty": "",
+		"path":  "/foo/bar",
+		"x":     "1024",
+		"y":     "768",
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{var}", "value"},
+		{"{hello}", "Hello%20World%21"},
+		{"{+var}", "value"},
+		{"{+hello}", "Hello%20World!"},
+		{"{+path}/here", "/foo/bar/here"},
+		{"here?ref={+path}", "here?ref=/foo/bar"},
+		{"X{#var}", "X#value"},
+		{"X{#hello}", "X#Hello%20World!"},
+		{"map?{x,y}", "map?1024,768"},
+		{"{x,hello,y}", "1024,Hello%20World%21,768"},
+		{"{+x,hello,y}", "1024,Hello%20World!,768"},
+		{"{+path,x}/here", "/foo/bar,1024/here"},
+		{"{#x,hello,y}", "#1024,Hello%20World!,768"},
+		{"{#path,x}/here", "#/foo/bar,1024/here"},
+		{"X{.var}", "X.value"},
+		{"X{.x,y}", "X.1024.768"},
+		{"{/var}", "/value"},
+		{"{/var,x}/here", "/value/1024/here"},
+		{"{;x,y}", ";x=1024;y=768"},
+		{"{;x,y,empty}", ";x=1024;y=768;empty"},
+		{"{?x,y}", "?x=1024&y=768"},
+		{"{?x,y,empty}", "?x=1024&y=768&empty="},
+		{"?fixed=yes{&x}", "?fixed=yes&x=1024"},
+		{"{&x,y,empty}", "&x=1024&y=768&empty="},
+		{"{?x,undef}", "?x=1024"},
+		{"{?undef}", ""},
+		{"/users/{var}{/x}", "/users/value/1024"},
+		{"/{hello}/ü", "/Hello%20World%21/ü"},
+	}
+
+	Convey("Test Expand Template", t, func() {
+		for _, tt := range tests {
+			got, err := ExpandTemplate(tt.template, vars)
+			So(err, ShouldBeNil)
+			So(got, ShouldEqual, tt.expected)
+		}
+
+		got, err := ExpandTemplate("/{id}", map[string]string{"id": "é/1"})
+		So(err, ShouldBeNil)
+		So(got, ShouldEqual, "/%C3%A9%2F1")
+
+		for _, template := range []string{"/{undef}", "/{+undef}", "/{var", "/{}", "/{a b}"} {
+			_, err := ExpandTemplate(template, vars)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Test Set Path Params", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s?%s", r.URL.EscapedPath(), r.URL.RawQuery)
+		}))
+		defer ts.Close()
+
+		r := New(SetBaseURL(ts.URL))
+		resp, err := r.Get(context.Background(), "/repos/{owner}/{repo}/issues{?state}", nil, SetPathParams(map[string]string{
+			"owner": "go lang",
+			"repo":  "a/b",
+			"state": "open",
+		}))
+		So(err, ShouldBeNil)
+		body, err := resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "/repos/go%20lang/a%2Fb/issues?state=open")
+
+		_, err = r.Get(context.Background(), "/repos/{owner}", nil, SetPathParams(map[string]string{}))
+		So(err, ShouldNotBeNil)
+
+		// without path params, braces are not expanded
+		resp, err = r.Get(context.Background(), "/users/{id}?filter={id}", nil)
+		So(err, ShouldBeNil)
+		body, err = resp.String()
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "/users/%7Bid%7D?filter={id}")
+	})
+}
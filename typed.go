@@ -0,0 +1,58 @@
+package req
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// GetJSON sends a GET request and decodes the response body into a T
+func GetJSON[T any](ctx context.Context, r Requester, urlStr string, queryParam url.Values, opts ...RequestOption) (T, Responser, error) {
+	return decodeAs[T](r.Get(ctx, urlStr, queryParam, acceptJSON(opts)...))
+}
+
+// PostJSONAs sends body as a JSON POST request and decodes the response
+// body into a Resp
+func PostJSONAs[Req, Resp any](ctx context.Context, r Requester, urlStr string, body Req, opts ...RequestOption) (Resp, Responser, error) {
+	return decodeAs[Resp](r.PostJSON(ctx, urlStr, body, acceptJSON(opts)...))
+}
+
+// PutJSONAs sends body as a JSON PUT request and decodes the response
+// body into a Resp
+func PutJSONAs[Req, Resp any](ctx context.Context, r Requester, urlStr string, body Req, opts ...RequestOption) (Resp, Responser, error) {
+	return decodeAs[Resp](r.PutJSON(ctx, urlStr, body, acceptJSON(opts)...))
+}
+
+// PostAs sends body as a POST request encoded with the codec registered
+// for contentType and decodes the response body into a T
+func PostAs[T any](ctx context.Context, r Requester, urlStr, contentType string, body interface{}, opts ...RequestOption) (T, Responser, error) {
+	return decodeAs[T](doEncoded(ctx, r, urlStr, http.MethodPost, contentType, body, opts...))
+}
+
+// PutAs sends body as a PUT request encoded with the codec registered
+// for contentType and decodes the response body into a T
+func PutAs[T any](ctx context.Context, r Requester, urlStr, contentType string, body interface{}, opts ...RequestOption) (T, Responser, error) {
+	return decodeAs[T](doEncoded(ctx, r, urlStr, http.MethodPut, contentType, body, opts...))
+}
+
+// DoAs sends a request and decodes the response body into a T according
+// to the response Content-Type
+func DoAs[T any](ctx context.Context, r Requester, urlStr, method string, body io.Reader, opts ...RequestOption) (T, Responser, error) {
+	return decodeAs[T](r.Do(ctx, urlStr, method, body, opts...))
+}
+
+func acceptJSON(opts []RequestOption) []RequestOption {
+	return append([]RequestOption{SetHeader(HeaderAccept, MIMEApplicationJSON)}, opts...)
+}
+
+func decodeAs[T any](resp Responser, err error) (T, Responser, error) {
+	var v T
+	if err != nil {
+		return v, resp, err
+	}
+	if err := Decode(resp, &v); err != nil {
+		return v, resp, err
+	}
+	return v, resp, nil
+}
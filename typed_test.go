@@ -0,0 +1,66 @@
+package req
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTyped(t *testing.T) {
+	type user struct {
+		ID   int    `json:"id" xml:"id"`
+		Name string `json:"name" xml:"name"`
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xml":
+			w.Header().Set(HeaderContentType, MIMEApplicationXMLCharsetUTF8)
+			fmt.Fprint(w, `<user><id>2</id><name>bob</name></user>`)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			var u user
+			if r.Method == http.MethodPost {
+				json.NewDecoder(r.Body).Decode(&u)
+				u.ID = 3
+			} else {
+				u = user{ID: 1, Name: "alice"}
+			}
+			w.Header().Set(HeaderContentType, MIMEApplicationJSONCharsetUTF8)
+			json.NewEncoder(w).Encode(u)
+		}
+	}))
+	defer ts.Close()
+
+	r := New(SetBaseURL(ts.URL))
+
+	Convey("Test GetJSON", t, func() {
+		u, resp, err := GetJSON[user](context.Background(), r, "/json", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+		So(u, ShouldResemble, user{ID: 1, Name: "alice"})
+	})
+
+	Convey("Test PostJSONAs", t, func() {
+		u, _, err := PostJSONAs[user, *user](context.Background(), r, "/json", user{Name: "carol"})
+		So(err, ShouldBeNil)
+		So(u, ShouldResemble, &user{ID: 3, Name: "carol"})
+	})
+
+	Convey("Test DoAs", t, func() {
+		u, _, err := DoAs[user](context.Background(), r, "/xml", http.MethodGet, nil)
+		So(err, ShouldBeNil)
+		So(u, ShouldResemble, user{ID: 2, Name: "bob"})
+
+		m, resp, err := DoAs[map[string]string](context.Background(), r, "/empty", http.MethodGet, nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusNoContent)
+		So(m, ShouldBeNil)
+	})
+}
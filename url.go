@@ -0,0 +1,150 @@
+package req
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SetBaseQuery set a query parameter sent with every request to a relative
+// url, unless the request url or query parameters already carry the same
+// key. Requests to absolute urls never carry it, so that credentials such
+// as API keys are not sent to other hosts
+func SetBaseQuery(key, value string) Option {
+	return func(o *options) {
+		if o.query == nil {
+			o.query = make(url.Values)
+		}
+		o.query.Set(key, value)
+	}
+}
+
+// RequestURL get request url, see ResolveURL. When the urls cannot be
+// parsed, base and router are simply joined with a slash
+func RequestURL(base, router string) string {
+	u, err := ResolveURL(base, router)
+	if err != nil {
+		if base == "" || router == "" {
+			return base + router
+		}
+		return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(router, "/")
+	}
+	return u.String()
+}
+
+// ResolveURL resolves ref against the base url of a client:
+//
+//   - an absolute ref, with a scheme, is used as is
+//   - a network-path ref ("//host/path") only keeps the scheme of base
+//   - otherwise the path of ref is appended to the path of base, with a
+//     single slash between them, so "http://host/api/v1" and "users"
+//     give "http://host/api/v1/users"
+//
+// The query parameters of base are kept unless ref sets the same key,
+// and the fragment is the one of ref
+func ResolveURL(base, ref string) (*url.URL, error) {
+	return resolveURL(base, ref, nil)
+}
+
+// resolveURL resolves ref, with the raw queries appended to its own,
+// against base
+func resolveURL(base, ref string, query []string) (*url.URL, error) {
+	r, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range query {
+		if r.RawQuery != "" {
+			r.RawQuery += "&"
+		}
+		r.RawQuery += q
+	}
+	if base == "" || r.Scheme != "" {
+		return r, nil
+	}
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if r.Host != "" {
+		r.Scheme = b.Scheme
+		return r, nil
+	}
+
+	u := *b
+	u.Fragment, u.RawFragment = r.Fragment, r.RawFragment
+	u.RawQuery = mergeQuery(b.RawQuery, r.RawQuery)
+	u.ForceQuery = r.ForceQuery && u.RawQuery == ""
+
+	if p := r.EscapedPath(); p != "" {
+		joined := strings.TrimSuffix(b.EscapedPath(), "/") + "/" + strings.TrimLeft(p, "/")
+		if err := setEscapedPath(&u, joined); err != nil {
+			return nil, err
+		}
+	}
+	return &u, nil
+}
+
+func setEscapedPath(u *url.URL, p string) error {
+	path, err := url.PathUnescape(p)
+	if err != nil {
+		return err
+	}
+	u.Path, u.RawPath = path, ""
+	if u.EscapedPath() != p {
+		u.RawPath = p
+	}
+	return nil
+}
+
+// mergeQuery appends the raw query q to the parameters of defaults whose
+// keys q does not set
+func mergeQuery(defaults, q string) string {
+	if defaults == "" {
+		return q
+	}
+	if q == "" {
+		return defaults
+	}
+
+	set, _ := url.ParseQuery(q)
+	var kept []string
+	for _, kv := range strings.Split(defaults, "&") {
+		k := kv
+		if i := strings.IndexByte(k, '='); i >= 0 {
+			k = k[:i]
+		}
+		if k, err := url.QueryUnescape(k); err == nil {
+			if _, ok := set[k]; ok {
+				continue
+			}
+		}
+		kept = append(kept, kv)
+	}
+	if len(kept) == 0 {
+		return q
+	}
+	return strings.Join(kept, "&") + "&" + q
+}
+
+// setQuery adds the query parameters of Get, Head, Delete and Patch
+func setQuery(param url.Values) RequestOption {
+	return func(o *requestOptions) {
+		if len(param) > 0 {
+			o.query = append(o.query, param.Encode())
+		}
+	}
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme != "" || u.Host != "")
+}
+
+func cloneValues(v url.Values) url.Values {
+	c := make(url.Values, len(v))
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
@@ -0,0 +1,491 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebDAV methods
+const (
+	MethodPropFind  = "PROPFIND"
+	MethodPropPatch = "PROPPATCH"
+	MethodMkcol     = "MKCOL"
+	MethodCopy      = "COPY"
+	MethodMove      = "MOVE"
+	MethodLock      = "LOCK"
+	MethodUnlock    = "UNLOCK"
+)
+
+// Depth is the value of the WebDAV Depth header
+type Depth string
+
+// Depth values
+const (
+	DepthZero     Depth = "0"
+	DepthOne      Depth = "1"
+	DepthInfinity Depth = "infinity"
+)
+
+// WebDAV properties defined by RFC 4918
+var (
+	PropCreationDate  = xml.Name{Space: "DAV:", Local: "creationdate"}
+	PropDisplayName   = xml.Name{Space: "DAV:", Local: "displayname"}
+	PropContentLength = xml.Name{Space: "DAV:", Local: "getcontentlength"}
+	PropContentType   = xml.Name{Space: "DAV:", Local: "getcontenttype"}
+	PropETag          = xml.Name{Space: "DAV:", Local: "getetag"}
+	PropLastModified  = xml.Name{Space: "DAV:", Local: "getlastmodified"}
+	PropResourceType  = xml.Name{Space: "DAV:", Local: "resourcetype"}
+	PropLockDiscovery = xml.Name{Space: "DAV:", Local: "lockdiscovery"}
+)
+
+// SetDepth set the WebDAV Depth header
+func SetDepth(depth Depth) RequestOption {
+	return SetHeader(HeaderDepth, string(depth))
+}
+
+// SetLockToken submits the token of a lock held on the request url
+// through the If header
+func SetLockToken(token string) RequestOption {
+	return SetHeader(HeaderIf, "(<"+token+">)")
+}
+
+// WebDAV is an RFC 4918 WebDAV client sending its requests through a
+// Requester. A non-2xx response is reported as a *StatusError and a 207
+// response to a request acting on several resources as a
+// *MultistatusError
+type WebDAV struct {
+	r Requester
+}
+
+// NewWebDAV create a WebDAV client on top of r
+func NewWebDAV(r Requester) *WebDAV {
+	return &WebDAV{r: r}
+}
+
+// Property is a WebDAV property, its value being kept as raw XML
+type Property struct {
+	XMLName  xml.Name
+	InnerXML []byte `xml:",innerxml"`
+}
+
+// NewProperty create a property whose value is the text value
+func NewProperty(name xml.Name, value string) Property {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(value))
+	return Property{XMLName: name, InnerXML: b.Bytes()}
+}
+
+// Text returns the character data of the property value
+func (p Property) Text() string {
+	var b strings.Builder
+	d := xml.NewDecoder(bytes.NewReader(p.InnerXML))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			b.Write(cd)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Multistatus is a 207 Multi-Status response body
+type Multistatus struct {
+	XMLName     xml.Name      `xml:"DAV: multistatus"`
+	Responses   []DAVResponse `xml:"DAV: response"`
+	Description string        `xml:"DAV: responsedescription"`
+}
+
+// DAVResponse is the status of a resource in a multistatus response,
+// either as a whole or for each of its properties
+type DAVResponse struct {
+	Hrefs       []string   `xml:"DAV: href"`
+	Status      string     `xml:"DAV: status"`
+	Propstats   []Propstat `xml:"DAV: propstat"`
+	Description string     `xml:"DAV: responsedescription"`
+}
+
+// Href returns the resource href
+func (r *DAVResponse) Href() string {
+	if len(r.Hrefs) == 0 {
+		return ""
+	}
+	return r.Hrefs[0]
+}
+
+// StatusCode returns the code of the resource status, 0 when the status
+// is reported per property
+func (r *DAVResponse) StatusCode() int {
+	return parseDAVStatus(r.Status)
+}
+
+// Prop returns the property name found on the resource
+func (r *DAVResponse) Prop(name xml.Name) (Property, bool) {
+	for _, ps := range r.Propstats {
+		if code := ps.StatusCode(); code < 200 || code > 299 {
+			continue
+		}
+		for _, p := range ps.Props {
+			if p.XMLName == name {
+				return p, true
+			}
+		}
+	}
+	return Property{}, false
+}
+
+// IsCollection reports whether the resource is a collection
+func (r *DAVResponse) IsCollection() bool {
+	p, ok := r.Prop(PropResourceType)
+	if !ok {
+		return false
+	}
+	d := xml.NewDecoder(bytes.NewReader(p.InnerXML))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return false
+		}
+		// the prefix of a raw value may be left unresolved
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "collection" {
+			return true
+		}
+	}
+}
+
+// Propstat is the status of some properties of a resource
+type Propstat struct {
+	Props       []Property
+	Status      string
+	Description string
+}
+
+// StatusCode returns the code of the properties status
+func (p *Propstat) StatusCode() int {
+	return parseDAVStatus(p.Status)
+}
+
+// UnmarshalXML implements xml.Unmarshaler
+func (p *Propstat) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		Prop        propList `xml:"DAV: prop"`
+		Status      string   `xml:"DAV: status"`
+		Description string   `xml:"DAV: responsedescription"`
+	}
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+	p.Props = raw.Prop.Props
+	p.Status = raw.Status
+	p.Description = raw.Description
+	return nil
+}
+
+type propList struct {
+	Props []Property `xml:",any"`
+}
+
+// MultistatusError reports the resources a request acting on several of
+// them failed on
+type MultistatusError struct {
+	Multistatus *Multistatus
+}
+
+func (e *MultistatusError) Error() string {
+	for _, r := range e.Multistatus.Responses {
+		if code := r.StatusCode(); code != 0 && (code < 200 || code > 299) {
+			return fmt.Sprintf("req: %s: %s", r.Href(), r.Status)
+		}
+	}
+	return "req: multistatus response"
+}
+
+// Options sends an OPTIONS request and parses the capabilities the
+// response advertises
+func (d *WebDAV) Options(ctx context.Context, urlStr string, opts ...RequestOption) (*Capabilities, error) {
+	return d.r.Capabilities(ctx, urlStr, opts...)
+}
+
+// PropFind fetches the props of the resource at urlStr and of its members
+// down to depth, all of its props if none is given
+func (d *WebDAV) PropFind(ctx context.Context, urlStr string, depth Depth, props []xml.Name, opts ...RequestOption) (*Multistatus, error) {
+	body := propfind{}
+	if len(props) == 0 {
+		body.AllProp = &struct{}{}
+	} else {
+		body.Prop = &propList{}
+		for _, name := range props {
+			body.Prop.Props = append(body.Prop.Props, Property{XMLName: name})
+		}
+	}
+
+	opts = append(opts[:len(opts):len(opts)], SetDepth(depth))
+	return d.multistatus(doEncoded(ctx, d.r, urlStr, MethodPropFind, MIMEApplicationXMLCharsetUTF8, body, d.options(opts)...))
+}
+
+// PropPatch sets then removes props of the resource at urlStr, the
+// returned multistatus reporting the outcome per property
+func (d *WebDAV) PropPatch(ctx context.Context, urlStr string, set []Property, remove []xml.Name, opts ...RequestOption) (*Multistatus, error) {
+	body := propertyupdate{}
+	if len(set) > 0 {
+		body.Set = &propAction{Prop: propList{Props: set}}
+	}
+	if len(remove) > 0 {
+		body.Remove = &propAction{}
+		for _, name := range remove {
+			body.Remove.Prop.Props = append(body.Remove.Prop.Props, Property{XMLName: name})
+		}
+	}
+
+	return d.multistatus(doEncoded(ctx, d.r, urlStr, MethodPropPatch, MIMEApplicationXMLCharsetUTF8, body, d.options(opts)...))
+}
+
+// Mkcol creates the collection at urlStr
+func (d *WebDAV) Mkcol(ctx context.Context, urlStr string, opts ...RequestOption) error {
+	return d.exec(ctx, urlStr, MethodMkcol, opts)
+}
+
+// Delete deletes the resource at urlStr and, for a collection, its members
+func (d *WebDAV) Delete(ctx context.Context, urlStr string, opts ...RequestOption) error {
+	return d.exec(ctx, urlStr, http.MethodDelete, opts)
+}
+
+// Copy copies the resource at src to dst, resolved against the src url.
+// An existing dst is replaced only if overwrite is true
+func (d *WebDAV) Copy(ctx context.Context, src, dst string, overwrite bool, opts ...RequestOption) error {
+	return d.exec(ctx, src, MethodCopy, append(opts[:len(opts):len(opts)], setDestination(dst, overwrite)))
+}
+
+// Move moves the resource at src to dst, resolved against the src url.
+// An existing dst is replaced only if overwrite is true
+func (d *WebDAV) Move(ctx context.Context, src, dst string, overwrite bool, opts ...RequestOption) error {
+	return d.exec(ctx, src, MethodMove, append(opts[:len(opts):len(opts)], setDestination(dst, overwrite)))
+}
+
+// LockInfo describes the lock requested by WebDAV.Lock
+type LockInfo struct {
+	Shared  bool          // a shared lock instead of an exclusive one
+	Owner   string        // text identifying the lock owner
+	Depth   Depth         // DepthZero or DepthInfinity, the default
+	Timeout time.Duration // zero requesting an infinite lock
+}
+
+// Lock is a WebDAV write lock
+type Lock struct {
+	Token   string
+	Root    string
+	Shared  bool
+	Depth   Depth
+	Owner   string
+	Timeout time.Duration // zero for an infinite lock
+}
+
+// Lock takes a write lock on the resource at urlStr
+func (d *WebDAV) Lock(ctx context.Context, urlStr string, info LockInfo, opts ...RequestOption) (*Lock, error) {
+	body := lockinfo{Type: lockType{Write: &struct{}{}}}
+	if info.Shared {
+		body.Scope.Shared = &struct{}{}
+	} else {
+		body.Scope.Exclusive = &struct{}{}
+	}
+	if info.Owner != "" {
+		body.Owner = &lockOwner{InnerXML: NewProperty(xml.Name{}, info.Owner).InnerXML}
+	}
+	if info.Depth == "" {
+		info.Depth = DepthInfinity
+	}
+
+	opts = append(opts[:len(opts):len(opts)], SetDepth(info.Depth), SetHeader(HeaderTimeout, formatDAVTimeout(info.Timeout)))
+	resp, err := doEncoded(ctx, d.r, urlStr, MethodLock, MIMEApplicationXMLCharsetUTF8, body, d.options(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseLock(resp)
+}
+
+// RefreshLock resets the timeout of the lock token held on the resource at
+// urlStr
+func (d *WebDAV) RefreshLock(ctx context.Context, urlStr, token string, timeout time.Duration, opts ...RequestOption) (*Lock, error) {
+	opts = append(opts[:len(opts):len(opts)], SetLockToken(token), SetHeader(HeaderTimeout, formatDAVTimeout(timeout)))
+	resp, err := d.r.Do(ctx, urlStr, MethodLock, nil, d.options(opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := parseLock(resp)
+	if err == nil && lock.Token == "" {
+		lock.Token = token
+	}
+	return lock, err
+}
+
+// Unlock releases the lock token held on the resource at urlStr
+func (d *WebDAV) Unlock(ctx context.Context, urlStr, token string, opts ...RequestOption) error {
+	return d.exec(ctx, urlStr, MethodUnlock, append(opts[:len(opts):len(opts)], SetHeader(HeaderLockToken, "<"+token+">")))
+}
+
+// options enforces status errors whatever the requester configuration
+func (d *WebDAV) options(opts []RequestOption) []RequestOption {
+	return append(opts[:len(opts):len(opts)], SetStatusError(true))
+}
+
+func (d *WebDAV) exec(ctx context.Context, urlStr, method string, opts []RequestOption) error {
+	resp, err := d.r.Do(ctx, urlStr, method, nil, d.options(opts)...)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	if resp.StatusCode() != http.StatusMultiStatus {
+		return nil
+	}
+	ms := new(Multistatus)
+	if err := Decode(resp, ms); err != nil {
+		return err
+	}
+	return &MultistatusError{Multistatus: ms}
+}
+
+func (d *WebDAV) multistatus(resp Responser, err error) (*Multistatus, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	ms := new(Multistatus)
+	if err := Decode(resp, ms); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// setDestination sets the Destination header to dst resolved against the
+// request url, which is only known once the request is filled
+func setDestination(dst string, overwrite bool) RequestOption {
+	return func(o *requestOptions) {
+		handle := o.handle
+		o.handle = func(req *http.Request) (*http.Request, error) {
+			ref, err := url.Parse(dst)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set(HeaderDestination, req.URL.ResolveReference(ref).String())
+			if overwrite {
+				req.Header.Set(HeaderOverwrite, "T")
+			} else {
+				req.Header.Set(HeaderOverwrite, "F")
+			}
+
+			if handle != nil {
+				return handle(req)
+			}
+			return req, nil
+		}
+	}
+}
+
+type propfind struct {
+	XMLName xml.Name  `xml:"DAV: propfind"`
+	AllProp *struct{} `xml:"DAV: allprop"`
+	Prop    *propList `xml:"DAV: prop"`
+}
+
+type propertyupdate struct {
+	XMLName xml.Name    `xml:"DAV: propertyupdate"`
+	Set     *propAction `xml:"DAV: set"`
+	Remove  *propAction `xml:"DAV: remove"`
+}
+
+type propAction struct {
+	Prop propList `xml:"DAV: prop"`
+}
+
+type lockinfo struct {
+	XMLName xml.Name   `xml:"DAV: lockinfo"`
+	Scope   lockScope  `xml:"DAV: lockscope"`
+	Type    lockType   `xml:"DAV: locktype"`
+	Owner   *lockOwner `xml:"DAV: owner"`
+}
+
+type lockScope struct {
+	Exclusive *struct{} `xml:"DAV: exclusive"`
+	Shared    *struct{} `xml:"DAV: shared"`
+}
+
+type lockType struct {
+	Write *struct{} `xml:"DAV: write"`
+}
+
+type lockOwner struct {
+	InnerXML []byte `xml:",innerxml"`
+}
+
+type activeLock struct {
+	Scope   lockScope `xml:"lockscope"`
+	Depth   string    `xml:"depth"`
+	Owner   lockOwner `xml:"owner"`
+	Timeout string    `xml:"timeout"`
+	Token   string    `xml:"locktoken>href"`
+	Root    string    `xml:"lockroot>href"`
+}
+
+func parseLock(resp Responser) (*Lock, error) {
+	var body struct {
+		Locks []activeLock `xml:"lockdiscovery>activelock"`
+	}
+	if err := Decode(resp, &body); err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{Token: strings.Trim(resp.Response().Header.Get(HeaderLockToken), "<>")}
+	for _, al := range body.Locks {
+		if lock.Token != "" && strings.TrimSpace(al.Token) != lock.Token {
+			continue
+		}
+		lock.Token = strings.TrimSpace(al.Token)
+		lock.Root = strings.TrimSpace(al.Root)
+		lock.Shared = al.Scope.Shared != nil
+		lock.Depth = Depth(strings.TrimSpace(al.Depth))
+		lock.Owner = Property{InnerXML: al.Owner.InnerXML}.Text()
+		lock.Timeout = parseDAVTimeout(al.Timeout)
+		break
+	}
+	return lock, nil
+}
+
+func formatDAVTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "Infinite"
+	}
+	return "Second-" + strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+func parseDAVTimeout(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "Second-") {
+		return 0
+	}
+	secs, err := strconv.ParseInt(s[len("Second-"):], 10, 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// parseDAVStatus parses the code of a status line such as "HTTP/1.1 200 OK"
+func parseDAVStatus(s string) int {
+	f := strings.Fields(s)
+	if len(f) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(f[1])
+	return code
+}
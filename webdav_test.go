@@ -0,0 +1,213 @@
+package req
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/webdav"
+)
+
+func TestCapabilities(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set(HeaderAllow, "GET, HEAD, PATCH")
+			w.Header().Add(HeaderAllow, "OPTIONS")
+			w.Header().Set(HeaderAcceptPatch, MIMEApplicationJSONPatch+", "+MIMEApplicationMergePatch)
+			if origin := r.Header.Get(HeaderOrigin); origin != "" {
+				w.Header().Set(HeaderAccessControlAllowOrigin, origin)
+				w.Header().Set(HeaderAccessControlAllowMethods, r.Header.Get(HeaderAccessControlRequestMethod))
+				w.Header().Set(HeaderAccessControlAllowHeaders, r.Header.Get(HeaderAccessControlRequestHeaders))
+				w.Header().Set(HeaderAccessControlAllowCredentials, "true")
+				w.Header().Set(HeaderAccessControlMaxAge, "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodTrace:
+			w.Header().Set(HeaderContentType, "message/http")
+			r.Write(w)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r := New(SetBaseURL(ts.URL))
+
+	Convey("Options", t, func() {
+		c, err := r.Capabilities(ctx, "/items")
+		So(err, ShouldBeNil)
+		So(c.Allow, ShouldResemble, []string{"GET", "HEAD", "PATCH", "OPTIONS"})
+		So(c.Allows(http.MethodPatch), ShouldBeTrue)
+		So(c.Allows(http.MethodPut), ShouldBeFalse)
+		So(c.AcceptPatch, ShouldResemble, []string{MIMEApplicationJSONPatch, MIMEApplicationMergePatch})
+		So(c.CORS.AllowOrigin, ShouldBeEmpty)
+	})
+
+	Convey("CORS preflight", t, func() {
+		c, err := r.Capabilities(ctx, "/items", SetPreflight("https://example.com", http.MethodPut, "X-Token", "Content-Type"))
+		So(err, ShouldBeNil)
+		So(c.CORS.AllowOrigin, ShouldEqual, "https://example.com")
+		So(c.CORS.AllowCredentials, ShouldBeTrue)
+		So(c.CORS.MaxAge, ShouldEqual, 10*time.Minute)
+		So(c.CORS.AllowsMethod(http.MethodPut), ShouldBeTrue)
+		So(c.CORS.AllowsMethod(http.MethodGet), ShouldBeTrue)
+		So(c.CORS.AllowsMethod(http.MethodDelete), ShouldBeFalse)
+		So(c.CORS.AllowsHeader("x-token"), ShouldBeTrue)
+		So(c.CORS.AllowsHeader("X-Other"), ShouldBeFalse)
+	})
+
+	Convey("Trace", t, func() {
+		resp, err := r.Trace(ctx, "/echo", SetHeader("X-Trace", "1"))
+		So(err, ShouldBeNil)
+		s, _ := resp.String()
+		So(s, ShouldStartWith, "TRACE /echo HTTP/1.1")
+		So(s, ShouldContainSubstring, "X-Trace: 1")
+	})
+}
+
+func TestWebDAV(t *testing.T) {
+	ts := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer ts.Close()
+
+	ctx := context.Background()
+	r := New(SetBaseURL(ts.URL))
+	dav := NewWebDAV(r)
+
+	Convey("WebDAV", t, func() {
+		c, err := dav.Options(ctx, "/")
+		So(err, ShouldBeNil)
+		So(c.DAV, ShouldContain, "2")
+		So(c.Allows(MethodPropFind), ShouldBeTrue)
+
+		So(dav.Mkcol(ctx, "/docs"), ShouldBeNil)
+		_, err = r.Put(ctx, "/docs/a.txt", strings.NewReader("hello"), SetStatusError(true))
+		So(err, ShouldBeNil)
+
+		Convey("Mkcol on an existing collection fails", func() {
+			var se *StatusError
+			So(errors.As(dav.Mkcol(ctx, "/docs"), &se), ShouldBeTrue)
+			So(se.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("PropFind", func() {
+			ms, err := dav.PropFind(ctx, "/docs/", DepthOne, nil)
+			So(err, ShouldBeNil)
+			So(ms.Responses, ShouldHaveLength, 2)
+
+			byHref := make(map[string]*DAVResponse)
+			for i := range ms.Responses {
+				byHref[ms.Responses[i].Href()] = &ms.Responses[i]
+			}
+			So(byHref["/docs/"].IsCollection(), ShouldBeTrue)
+
+			file := byHref["/docs/a.txt"]
+			So(file, ShouldNotBeNil)
+			So(file.IsCollection(), ShouldBeFalse)
+			length, ok := file.Prop(PropContentLength)
+			So(ok, ShouldBeTrue)
+			So(length.Text(), ShouldEqual, "5")
+
+			ms, err = dav.PropFind(ctx, "/docs/a.txt", DepthZero, []xml.Name{PropDisplayName, {Space: "urn:x", Local: "missing"}})
+			So(err, ShouldBeNil)
+			So(ms.Responses, ShouldHaveLength, 1)
+			name, ok := ms.Responses[0].Prop(PropDisplayName)
+			So(ok, ShouldBeTrue)
+			So(name.Text(), ShouldEqual, "a.txt")
+			_, ok = ms.Responses[0].Prop(xml.Name{Space: "urn:x", Local: "missing"})
+			So(ok, ShouldBeFalse)
+
+			_, err = dav.PropFind(ctx, "/nope", DepthZero, nil)
+			So(err, ShouldHaveSameTypeAs, &StatusError{})
+		})
+
+		Convey("PropPatch", func() {
+			color := xml.Name{Space: "urn:x", Local: "color"}
+			ms, err := dav.PropPatch(ctx, "/docs/a.txt", []Property{NewProperty(color, "red & blue")}, nil)
+			So(err, ShouldBeNil)
+			So(ms.Responses, ShouldHaveLength, 1)
+			So(ms.Responses[0].Propstats[0].StatusCode(), ShouldEqual, http.StatusOK)
+
+			ms, err = dav.PropFind(ctx, "/docs/a.txt", DepthZero, []xml.Name{color})
+			So(err, ShouldBeNil)
+			p, ok := ms.Responses[0].Prop(color)
+			So(ok, ShouldBeTrue)
+			So(p.Text(), ShouldEqual, "red & blue")
+
+			_, err = dav.PropPatch(ctx, "/docs/a.txt", nil, []xml.Name{color})
+			So(err, ShouldBeNil)
+			ms, err = dav.PropFind(ctx, "/docs/a.txt", DepthZero, []xml.Name{color})
+			So(err, ShouldBeNil)
+			_, ok = ms.Responses[0].Prop(color)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Copy and Move", func() {
+			So(dav.Copy(ctx, "/docs/a.txt", "b.txt", false), ShouldBeNil)
+
+			var se *StatusError
+			err := dav.Copy(ctx, "/docs/a.txt", "/docs/b.txt", false)
+			So(errors.As(err, &se), ShouldBeTrue)
+			So(se.StatusCode, ShouldEqual, http.StatusPreconditionFailed)
+			So(dav.Copy(ctx, "/docs/a.txt", "/docs/b.txt", true), ShouldBeNil)
+
+			So(dav.Move(ctx, "/docs/b.txt", ts.URL+"/c.txt", false), ShouldBeNil)
+			resp, err := r.Get(ctx, "/c.txt", nil)
+			So(err, ShouldBeNil)
+			s, _ := resp.String()
+			So(s, ShouldEqual, "hello")
+
+			_, err = dav.PropFind(ctx, "/docs/b.txt", DepthZero, nil)
+			So(errors.As(err, &se), ShouldBeTrue)
+			So(se.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			So(dav.Delete(ctx, "/c.txt"), ShouldBeNil)
+		})
+
+		Convey("Options of the caller are left untouched", func() {
+			opts := make([]RequestOption, 1, 4)
+			opts[0] = SetHeader("X-Test", "1")
+			So(dav.Copy(ctx, "/docs/a.txt", "/docs/d.txt", true, opts...), ShouldBeNil)
+			So(dav.Delete(ctx, "/docs/d.txt", opts...), ShouldBeNil)
+			So(opts[:cap(opts)][1:], ShouldResemble, []RequestOption{nil, nil, nil})
+		})
+
+		Convey("Lock and Unlock", func() {
+			lock, err := dav.Lock(ctx, "/docs/a.txt", LockInfo{Owner: "alice", Depth: DepthZero, Timeout: time.Minute})
+			So(err, ShouldBeNil)
+			So(lock.Token, ShouldNotBeEmpty)
+			So(lock.Owner, ShouldEqual, "alice")
+			So(lock.Depth, ShouldEqual, DepthZero)
+			So(lock.Shared, ShouldBeFalse)
+			So(lock.Timeout, ShouldEqual, time.Minute)
+
+			var se *StatusError
+			_, err = r.Put(ctx, "/docs/a.txt", strings.NewReader("x"), SetStatusError(true))
+			So(errors.As(err, &se), ShouldBeTrue)
+			So(se.StatusCode, ShouldEqual, http.StatusLocked)
+			_, err = r.Put(ctx, "/docs/a.txt", strings.NewReader("locked write"), SetStatusError(true), SetLockToken(lock.Token))
+			So(err, ShouldBeNil)
+
+			refreshed, err := dav.RefreshLock(ctx, "/docs/a.txt", lock.Token, time.Hour)
+			So(err, ShouldBeNil)
+			So(refreshed.Token, ShouldEqual, lock.Token)
+			So(refreshed.Timeout, ShouldEqual, time.Hour)
+
+			So(dav.Unlock(ctx, "/docs/a.txt", lock.Token), ShouldBeNil)
+			_, err = r.Put(ctx, "/docs/a.txt", strings.NewReader("hello"), SetStatusError(true))
+			So(err, ShouldBeNil)
+		})
+
+		Reset(func() {
+			dav.Delete(ctx, "/docs")
+		})
+	})
+}